@@ -28,7 +28,7 @@ func initBlueprint(workDir string) error {
 		return err
 	}
 	if isBlueprintRepo {
-		return errors.New("Reinitialized existing Blueprint repository in " + path.Join(workDir, "blueprint.json"))
+		return errors.New("Reinitialized existing Blueprint repository in " + configPath(workDir))
 	}
 
 	cnf := Config{}
@@ -101,34 +101,38 @@ func initBlueprint(workDir string) error {
 	}
 
 	err = os.WriteFile(
-		path.Join(workDir, BlueprintConfigFileName),
+		configPath(workDir),
 		cnfBytes,
 		0644)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("Initialized Blueprint repository in " + path.Join(workDir, BlueprintConfigFileName))
+	result.AddFile(configPath(workDir))
+	logger.Info(fmt.Sprintf("Initialized Blueprint repository in %s", configPath(workDir)))
 	return nil
 }
 
-func runMigration(migrationPath string, dbs []*DBConnection) error {
+// 执行 migration，phase 不为空时只执行该阶段的 migration
+func runMigration(migrationPath string, dbs []*DBConnection, phase MigrationPhase) error {
 	// 读取 migration 文件
-	migrations, err := LoadMigrations(migrationPath)
+	migrations, err := LoadMigrations(migrationPath, config.Hooks)
 	if err != nil {
 		return err
 	}
 
 	for _, db := range dbs {
+		dbResult := result.AddDatabase(db)
+
 		err := db.Driver.CheckMigrationInfoTable(db.DB)
 		if err != nil {
-			return fmt.Errorf("check migration info failed: %s", err.Error())
+			return errorf(ExitDatabase, "check migration info failed: %s", err.Error())
 		}
 
 		maxBatch := uint(0)
 		recs, err := db.Driver.GetMigrationInfos(db.DB)
 		if err != nil {
-			return fmt.Errorf("get migration infos error: %s", err.Error())
+			return errorf(ExitDatabase, "get migration infos error: %s", err.Error())
 		}
 		recMap := make(map[string]struct{})
 		for _, rec := range recs {
@@ -138,36 +142,266 @@ func runMigration(migrationPath string, dbs []*DBConnection) error {
 			recMap[rec.Migration] = struct{}{}
 		}
 
+		outOfOrder := migrations.GetOutOfOrder(recMap, config.Env)
+		if len(outOfOrder) > 0 {
+			switch config.OutOfOrder {
+			case OutOfOrderDeny:
+				return errorf(ExitDrift, "db[%s] has pending migrations older than the latest applied one: %s",
+					db.Config.Label(), strings.Join(outOfOrder, ", "))
+			case OutOfOrderWarn:
+				for _, name := range outOfOrder {
+					warnf("%s is older than the latest applied migration of db[%s]", name, db.Config.Label())
+				}
+			}
+		}
+
 		maxBatch++
+		dbResult.Batch = maxBatch
+		dbResult.span.SetAttr("blueprint.batch", maxBatch)
+		pending := make([]string, 0)
+		pendingPre := make([]string, 0)
+		pendingPost := make([]string, 0)
+		running := make(map[string]struct{})
+		for _, name := range migrations.GetNames() {
+			info := migrations.GetInfo(name)
+			if _, exist := recMap[name]; exist || !info.AppliesTo(config.Env) {
+				continue
+			}
+			if info.Phase == PhasePre {
+				pendingPre = append(pendingPre, name)
+			} else {
+				pendingPost = append(pendingPost, name)
+			}
+			if phase == "" || info.Phase == phase {
+				running[name] = struct{}{}
+			}
+		}
+		// 不指定阶段时，全部 pre 阶段的 migration 都在 post 阶段之前执行，和分两次执行的顺序一致
+		order := migrations.GetNames()
+		switch phase {
+		case PhasePre:
+			pending = pendingPre
+		case PhasePost:
+			pending = pendingPost
+		default:
+			pending = append(append(pending, pendingPre...), pendingPost...)
+			order = make([]string, 0, len(migrations.GetNames()))
+			for _, name := range migrations.GetNames() {
+				if _, exist := running[name]; !exist || migrations.GetInfo(name).Phase == PhasePre {
+					order = append(order, name)
+				}
+			}
+			order = append(order, pendingPost...)
+		}
+		if phase == PhasePost && len(pending) > 0 && len(pendingPre) > 0 {
+			return errorf(ExitRefused, "db[%s] has pending pre-deploy migrations, run --phase pre first: %s",
+				db.Config.Label(), strings.Join(pendingPre, ", "))
+		}
+		// 依赖的 migration 必须已执行或在这次执行
+		for _, name := range pending {
+			for _, dependency := range migrations.GetInfo(name).DependsOn {
+				if _, exist := recMap[dependency]; exist {
+					continue
+				}
+				if _, exist := running[dependency]; exist {
+					// 移到最后执行的 post 阶段 migration 不能被 pre 阶段的 migration 依赖
+					if migrations.GetInfo(name).Phase == PhasePre && migrations.GetInfo(dependency).Phase == PhasePost {
+						return errorf(ExitConfig, "%s is a pre-deploy migration but depends on %s, which is a post-deploy migration", name, dependency)
+					}
+					continue
+				}
+				if !migrations.GetInfo(dependency).AppliesTo(config.Env) {
+					return errorf(ExitConfig, "%s depends on %s, which does not apply to env %q", name, dependency, config.Env)
+				}
+				return errorf(ExitConfig, "%s depends on %s, which is a %s-deploy migration", name, dependency, migrations.GetInfo(dependency).Phase)
+			}
+		}
+		hooks := hookContext{workDir: migrationPath, db: db, span: dbResult.span, batch: maxBatch, migrations: pending}
+		if len(pending) > 0 {
+			err = runHooks(HookBeforeRun, hooks, db.DB)
+			if err != nil {
+				return withCode(ExitRefused, err)
+			}
+		}
+		notifyStart(dbResult, pending)
 		err = DoTransaction(db.DB, func(tx *sql.Tx) error {
-			for idx, name := range migrations.GetNames() {
+			for idx, name := range order {
 				if _, exist := recMap[name]; exist {
-					fmt.Printf("[%d] %s had excuted, skip\n", idx, name)
+					logger.Info(fmt.Sprintf("[%d] %s had excuted, skip", idx, name))
+					dbResult.AddMigration(name, 0, StatusSkipped)
 					continue
 				}
-				fmt.Printf("[%d] %s\n", idx, name)
+				// 不适用于当前环境的 migration 不执行，也不记录
+				if info := migrations.GetInfo(name); !info.AppliesTo(config.Env) {
+					logger.Info(fmt.Sprintf("[%d] %s only runs in env %s, skip", idx, name, strings.Join(info.Envs, ", ")))
+					dbResult.AddMigration(name, 0, StatusNotApplicable)
+					continue
+				}
+				if _, exist := running[name]; !exist {
+					info := migrations.GetInfo(name)
+					logger.Info(fmt.Sprintf("[%d] %s is a %s-deploy migration, skip", idx, name, info.Phase))
+					dbResult.AddMigration(name, 0, StatusPending, string(info.Phase)+"_deploy")
+					continue
+				}
+				logger.Info(fmt.Sprintf("[%d] %s", idx, name))
+				migrationResult := dbResult.AddMigration(name, maxBatch, StatusApplied)
 				migration := migrations.GetInfo(name)
 				err := migration.LoadSQLFile()
 				if err != nil {
+					migrationResult.Status, migrationResult.Error = StatusFailed, err.Error()
+					return err
+				}
+				err = runHooks(HookBeforeMigration, hooks.withMigration(name, maxBatch), tx)
+				if err != nil {
+					migrationResult.Status, migrationResult.Error = StatusFailed, err.Error()
 					return err
 				}
 				upSQL := migration.upSQL
-				err = db.Driver.ExecMigration(tx, upSQL)
+				migrationResult.Checksum = sqlChecksum(upSQL)
+				span := tracer.StartSpan(dbResult.span, "migration "+name, spanKindInternal,
+					"blueprint.migration", name, "blueprint.batch", maxBatch)
+				start := time.Now()
+				statements, err := db.ExecMigration(tx, upSQL, span)
+				migrationResult.SetStatements(statements, time.Since(start))
+				span.End(err)
 				if err != nil {
+					migrationResult.Status, migrationResult.Error = StatusFailed, err.Error()
 					return err
 				}
 				err = db.Driver.InsertMigrationInfo(tx, MigrationRec{
 					Migration: name,
 					Batch:     maxBatch,
+					DownSQL:   sql.NullString{String: migration.GetDownSQL(), Valid: true},
 				})
 				if err != nil {
+					// SQL 已经执行，记录失败时整个事务回滚，这个 migration 也算失败
+					migrationResult.Status, migrationResult.Error = StatusFailed, "record migration failed: "+err.Error()
+					return err
+				}
+				err = runHooks(HookAfterMigration, hooks.withMigration(name, maxBatch).withStatus(nil), tx)
+				if err != nil {
+					migrationResult.Status, migrationResult.Error = StatusFailed, err.Error()
 					return err
 				}
 			}
 			return nil
 		})
+		logTimingSummary(db, dbResult)
+		if len(pending) > 0 {
+			// 事务已经结束，after_run 钩子失败只给出警告
+			if hookErr := runHooks(HookAfterRun, hooks.withStatus(err), db.DB); hookErr != nil {
+				warnf("%s", hookErr)
+			}
+		}
 		if err != nil {
-			return err
+			dbResult.MarkNotRecorded()
+			dbResult.Error = err.Error()
+		}
+		notifyDatabaseFinished(dbResult, err)
+		if err != nil {
+			return withCode(ExitMigration, err)
+		}
+	}
+
+	return nil
+}
+
+// 查看各数据库的 migration 执行情况，only 不为空时只显示这些 migration
+func showStatus(migrationPath string, dbs []*DBConnection, only []string) error {
+	migrations, err := LoadMigrations(migrationPath, config.Hooks)
+	if err != nil {
+		return err
+	}
+	selected := make(map[string]bool)
+	for _, name := range only {
+		name = strings.TrimSuffix(name, ".sql")
+		if !migrations.Exists(name) {
+			return usageError("no migration named " + name)
+		}
+		selected[name] = true
+	}
+	shown := func(name string) bool {
+		return len(selected) == 0 || selected[name]
+	}
+
+	for i, db := range dbs {
+		dbResult := result.AddDatabase(db)
+
+		err := db.Driver.CheckMigrationInfoTable(db.DB)
+		if err != nil {
+			return errorf(ExitDatabase, "check migration info failed: %s", err.Error())
+		}
+
+		recs, err := db.Driver.GetMigrationInfos(db.DB)
+		if err != nil {
+			return errorf(ExitDatabase, "get migration infos error: %s", err.Error())
+		}
+		recMap := make(map[string]struct{})
+		batches := make(map[string]uint)
+		for _, rec := range recs {
+			recMap[rec.Migration] = struct{}{}
+			batches[rec.Migration] = rec.Batch
+		}
+		outOfOrder := make(map[string]struct{})
+		for _, name := range migrations.GetOutOfOrder(recMap, config.Env) {
+			if shown(name) {
+				outOfOrder[name] = struct{}{}
+			}
+		}
+
+		if i > 0 {
+			echof("\n")
+		}
+		echof("Database[%d] %s\n", i+1, db.Config.Label())
+		echof("  %-8s %-6s %s\n", "Status", "Batch", "Migration")
+		notApplicable := 0
+		pendingByPhase := make(map[MigrationPhase]int)
+		for _, name := range migrations.GetNames() {
+			if !shown(name) {
+				continue
+			}
+			if batch, exist := batches[name]; exist {
+				echof("  %-8s %-6d %s\n", "Ran", batch, name)
+				dbResult.AddMigration(name, batch, StatusApplied)
+				continue
+			}
+			info := migrations.GetInfo(name)
+			if !info.AppliesTo(config.Env) {
+				echof("  %-8s %-6s %s  <- env: %s\n", "N/A", "", name, strings.Join(info.Envs, ", "))
+				dbResult.AddMigration(name, 0, StatusNotApplicable)
+				notApplicable++
+				continue
+			}
+			pendingByPhase[info.Phase]++
+			notes, marks := make([]string, 0), make([]string, 0)
+			if _, exist := outOfOrder[name]; exist {
+				notes, marks = append(notes, "out_of_order"), append(marks, "out of order")
+			}
+			if info.Phase == PhasePost {
+				notes, marks = append(notes, "post_deploy"), append(marks, "post-deploy")
+			}
+			if len(marks) > 0 {
+				echof("  %-8s %-6s %s  <- %s\n", "Pending", "", name, strings.Join(marks, ", "))
+			} else {
+				echof("  %-8s %-6s %s\n", "Pending", "", name)
+			}
+			dbResult.AddMigration(name, 0, StatusPending, notes...)
+		}
+		for _, rec := range recs {
+			if !migrations.Exists(rec.Migration) && len(selected) == 0 {
+				echof("  %-8s %-6d %s  <- file missing\n", "Ran", rec.Batch, rec.Migration)
+				dbResult.AddMigration(rec.Migration, rec.Batch, StatusApplied, "file_missing")
+			}
+		}
+		if len(outOfOrder) > 0 {
+			echof("  %d pending migration(s) are older than the latest applied one (policy: %s)\n",
+				len(outOfOrder), config.OutOfOrder)
+		}
+		if migrations.HasPhase(PhasePost) {
+			echof("  pending: %d pre-deploy, %d post-deploy migration(s)\n", pendingByPhase[PhasePre], pendingByPhase[PhasePost])
+		}
+		if notApplicable > 0 {
+			echof("  %d migration(s) are not applicable to env %q\n", notApplicable, config.Env)
 		}
 	}
 
@@ -175,29 +409,80 @@ func runMigration(migrationPath string, dbs []*DBConnection) error {
 }
 
 // 创建一对 Migration 文件
-func createMigration(workDir, action string, params []string) error {
+func createMigration(workDir, action, tableName, subDir string, irreversible, singleFile bool) error {
 	if ok, _ := isBlueprintRepo(workDir); !ok {
-		return errors.New("Not a Blueprint repository (" + workDir + ")")
+		return withCode(ExitConfig, errors.New("Not a Blueprint repository ("+workDir+")"))
 	}
 
-	tableName := ""
-	if len(params) == 0 {
+	// --path 是相对于仓库目录的子目录
+	subDir = filepath.Clean(subDir)
+	if filepath.IsAbs(subDir) || subDir == ".." || strings.HasPrefix(subDir, ".."+string(filepath.Separator)) {
+		return usageError("--path must be a directory inside the repository: " + subDir)
+	}
+	if tableName == "" {
 		tableName, _ = input("input table name: ")
-	} else {
-		tableName = params[0]
 	}
-	name, rbName := getMigrationFilename(fmt.Sprintf("%s_%s", action, tableName))
-	f, err := os.Create(path.Join(workDir, name))
+
+	err := loadJsonConfig(workDir)
+	if err != nil {
+		return withCode(ExitConfig, err)
+	}
+	namer, err := newMigrationNamer(workDir, config.Naming)
+	if err != nil {
+		return err
+	}
+	name, rbName := namer.Filename(fmt.Sprintf("%s_%s", action, tableName))
+
+	// migration 名称在所有子目录中必须唯一，文件也不能覆盖
+	migrations, err := LoadMigrations(workDir, config.Hooks)
+	if err != nil {
+		return err
+	}
+	if migrationName := strings.TrimSuffix(name, ".sql"); migrations.Exists(migrationName) {
+		return fmt.Errorf("migration %s already exists", migrationName)
+	}
+
+	dir := filepath.Join(workDir, subDir)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	if singleFile {
+		content := "-- +blueprint Up\n\n\n-- +blueprint Down\n\n"
+		if irreversible {
+			// 不可回滚的 migration 没有 Down 部分
+			content = "-- +blueprint irreversible\n-- +blueprint Up\n\n"
+		}
+		err = createFile(filepath.Join(dir, name), content)
+		if err != nil {
+			return errors.New("Create migration file failed: " + err.Error())
+		}
+		result.AddFile(filepath.Join(dir, name))
+		return nil
+	}
+
+	if irreversible {
+		// 不可回滚的 migration 没有回滚文件
+		err = createFile(filepath.Join(dir, name), "-- +blueprint irreversible\n")
+		if err != nil {
+			return errors.New("Create migration file failed: " + err.Error())
+		}
+		result.AddFile(filepath.Join(dir, name))
+		return nil
+	}
+
+	err = createFile(filepath.Join(dir, name), "")
 	if err != nil {
 		return errors.New("Create migration file failed: " + err.Error())
 	}
-	f.Close()
+	result.AddFile(filepath.Join(dir, name))
 
-	f, err = os.Create(path.Join(workDir, rbName))
+	err = createFile(filepath.Join(dir, rbName), "")
 	if err != nil {
 		return errors.New("Create migration rollback file failed: " + err.Error())
 	}
-	f.Close()
+	result.AddFile(filepath.Join(dir, rbName))
 
 	return nil
 }
@@ -218,10 +503,10 @@ func dumpSchemas(db *DBConnection, workDir string, forceDump bool) error {
 		return err
 	}
 
-	// Filter out migrations table
+	// Filter out migrations, audit and seed table
 	filteredTables := make([]string, 0)
 	for _, table := range tables {
-		if table == "migrations" {
+		if table == "migrations" || table == AuditTableName || table == SeedTableName {
 			continue
 		}
 		filteredTables = append(filteredTables, table)
@@ -238,27 +523,29 @@ func dumpSchemas(db *DBConnection, workDir string, forceDump bool) error {
 	}
 
 	writeSqlFile := func(table, creationFilename, creation, rollbackFilename, rollback string) error {
-		fmt.Printf("Writing table[%s] creation to %s\n", table, creationFilename)
-		err := os.WriteFile(
-			creationFilename,
-			[]byte(creation),
-			0644,
-		)
+		logger.Info(fmt.Sprintf("Writing table[%s] creation to %s", table, creationFilename))
+		err := createFile(creationFilename, creation)
 		if err != nil {
 			return err
 		}
+		result.AddFile(creationFilename)
 
-		fmt.Printf("Writing table[%s] rollback to %s\n", table, rollbackFilename)
-		err = os.WriteFile(
-			rollbackFilename,
-			[]byte(rollback),
-			0644,
-		)
+		logger.Info(fmt.Sprintf("Writing table[%s] rollback to %s", table, rollbackFilename))
+		err = createFile(rollbackFilename, rollback)
+		if err != nil {
+			return err
+		}
+		result.AddFile(rollbackFilename)
+		return nil
+	}
+
+	namer, err := newMigrationNamer(workDir, config.Naming)
+	if err != nil {
 		return err
 	}
 
 	for _, table := range tables {
-		mName, rbName := getMigrationFilename(fmt.Sprintf("create_%s", strings.ToLower(table)))
+		mName, rbName := namer.Filename(fmt.Sprintf("create_%s", strings.ToLower(table)))
 		creation := creations[table]
 		rollback := fmt.Sprintf("DROP TABLE `%s`;\n", table)
 
@@ -289,9 +576,27 @@ func DoTransaction(db *sql.DB, fn func(tx *sql.Tx) error) error {
 	return tx.Commit()
 }
 
+// 已执行但文件已被删除的 migration 在回滚时的处理方式
+type MissingFilePolicy string
+
+const (
+	MissingError  MissingFilePolicy = "error"  // 报错并列出这些 migration（默认）
+	MissingSkip   MissingFilePolicy = "skip"   // 跳过，保留记录
+	MissingStored MissingFilePolicy = "stored" // 使用执行时保存的回滚 SQL
+	MissingPrune  MissingFilePolicy = "prune"  // 确认后直接删除记录，不执行回滚
+)
+
+func (p MissingFilePolicy) IsValid() bool {
+	switch p {
+	case MissingError, MissingSkip, MissingStored, MissingPrune:
+		return true
+	}
+	return false
+}
+
 // 回滚
-func rollbackMigration(migrationPath string, dbs []*DBConnection, step, batch int) error {
-	migrations, err := LoadMigrations(migrationPath)
+func rollbackMigration(migrationPath string, dbs []*DBConnection, step, batch int, preferFile bool, missingPolicy MissingFilePolicy) error {
+	migrations, err := LoadMigrations(migrationPath, config.Hooks)
 	if err != nil {
 		return err
 	}
@@ -301,13 +606,21 @@ func rollbackMigration(migrationPath string, dbs []*DBConnection, step, batch in
 	}
 
 	for _, db := range dbs {
+		dbResult := result.AddDatabase(db)
+
+		// 旧版本创建的表还没有 down_sql 字段，读取记录前先补上
+		err := db.Driver.CheckMigrationInfoTable(db.DB)
+		if err != nil {
+			return errorf(ExitDatabase, "check migration info failed: %s", err.Error())
+		}
+
 		recs, err := db.Driver.GetMigrationInfos(db.DB)
 		if err != nil {
-			return err
+			return withCode(ExitDatabase, err)
 		}
 
 		if len(recs) == 0 {
-			return fmt.Errorf("nothing to rollback")
+			return errorf(ExitNothingToDo, "nothing to rollback")
 		}
 
 		remainStep := 0
@@ -355,46 +668,275 @@ func rollbackMigration(migrationPath string, dbs []*DBConnection, step, batch in
 			}
 		}
 
+		// 在事务开始前找出文件已不存在的 migration
+		list, prune, err := checkMissingFiles(db, dbResult, migrations, list, missingPolicy)
+		if err != nil {
+			return err
+		}
+
+		err = checkDependents(db, migrations, recs, list)
+		if err != nil {
+			return err
+		}
+
+		// 先准备好全部回滚 SQL，遇到不可回滚或没有回滚 SQL 的 migration 时在执行前停止
+		downSQLs := make(map[uint]string)
+		for idx, migrRec := range list {
+			if _, exist := prune[migrRec.Id]; exist {
+				continue
+			}
+
+			hint := ""
+			if idx > 0 {
+				hint = fmt.Sprintf(" (use --step %d to roll back the migrations after it)", idx)
+			}
+
+			var downSQL string
+			if migrations.Exists(migrRec.Migration) {
+				migration := migrations.GetInfo(migrRec.Migration)
+				if migration.IsIrreversible() {
+					return errorf(ExitRefused, "Batch[%d] %s is irreversible, nothing was rolled back%s", migrRec.Batch, migrRec.Migration, hint)
+				}
+				downSQL, err = resolveDownSQL(migrRec, migration, preferFile)
+				if err != nil {
+					return err
+				}
+			} else {
+				downSQL = migrRec.DownSQL.String
+			}
+
+			// 没有声明为不可回滚，回滚 SQL 却为空，多半是忘了写回滚文件
+			if IsEmptySQL(downSQL) {
+				return errorf(ExitRefused, "Batch[%d] %s has no rollback SQL (its rollback file is missing or empty), nothing was rolled back%s; "+
+					"add `-- +blueprint irreversible` to the migration if it cannot be rolled back",
+					migrRec.Batch, migrRec.Migration, hint)
+			}
+			downSQLs[migrRec.Id] = downSQL
+		}
+
+		names := make([]string, 0, len(list))
+		for _, migrRec := range list {
+			names = append(names, migrRec.Migration)
+		}
+		batchOf := uint(0)
+		if len(list) > 0 {
+			batchOf = list[0].Batch
+		}
+		hooks := hookContext{workDir: migrationPath, db: db, span: dbResult.span, batch: batchOf, migrations: names}
+		if len(list) > 0 {
+			err = runHooks(HookBeforeRun, hooks, db.DB)
+			if err != nil {
+				return withCode(ExitRefused, err)
+			}
+		}
+		notifyStart(dbResult, names)
+
 		err = DoTransaction(db.DB, func(tx *sql.Tx) error {
 			for _, migrRec := range list {
-				migration := migrations.GetInfo(migrRec.Migration)
+				if _, exist := prune[migrRec.Id]; exist {
+					err := db.Driver.DeleteMigrationInfo(tx, migrRec.Id)
+					if err != nil {
+						return err
+					}
+					logger.Info(fmt.Sprintf("[%d] Batch[%d] %s orphan record removed", migrRec.Id, migrRec.Batch, migrRec.Migration))
+					dbResult.AddMigration(migrRec.Migration, migrRec.Batch, StatusPruned, "file_missing")
+					continue
+				}
 
 				// 执行回滚
-				err = migration.LoadSQLFile()
+				logger.Info(fmt.Sprintf("[%d] Batch[%d] %s rolling back", migrRec.Id, migrRec.Batch, migrRec.Migration))
+				migrationResult := dbResult.AddMigration(migrRec.Migration, migrRec.Batch, StatusRolledBack)
+				err := runHooks(HookBeforeMigration, hooks.withMigration(migrRec.Migration, migrRec.Batch), tx)
 				if err != nil {
+					migrationResult.Status, migrationResult.Error = StatusFailed, err.Error()
 					return err
 				}
-				downSQL := migration.GetDownSQL()
-				err = db.Driver.ExecMigration(tx, downSQL)
+				migrationResult.Checksum = sqlChecksum(downSQLs[migrRec.Id])
+				span := tracer.StartSpan(dbResult.span, "rollback "+migrRec.Migration, spanKindInternal,
+					"blueprint.migration", migrRec.Migration, "blueprint.batch", migrRec.Batch)
+				start := time.Now()
+				statements, err := db.ExecMigration(tx, downSQLs[migrRec.Id], span)
+				migrationResult.SetStatements(statements, time.Since(start))
+				span.End(err)
 				if err != nil {
+					migrationResult.Status, migrationResult.Error = StatusFailed, err.Error()
 					return err
 				}
 
 				// 删除 migration 记录
 				err = db.Driver.DeleteMigrationInfo(tx, migrRec.Id)
 				if err != nil {
+					migrationResult.Status, migrationResult.Error = StatusFailed, "delete migration record failed: "+err.Error()
 					return err
 				}
-				fmt.Printf("[%d] Batch[%d] %s rolled back\n", migrRec.Id, migrRec.Batch, migrRec.Migration)
+				err = runHooks(HookAfterMigration, hooks.withMigration(migrRec.Migration, migrRec.Batch).withStatus(nil), tx)
+				if err != nil {
+					migrationResult.Status, migrationResult.Error = StatusFailed, err.Error()
+					return err
+				}
+				logger.Info(fmt.Sprintf("[%d] Batch[%d] %s rolled back", migrRec.Id, migrRec.Batch, migrRec.Migration))
 			}
 			return nil
 		})
+		logTimingSummary(db, dbResult)
+		if len(list) > 0 {
+			// 事务已经结束，after_run 钩子失败只给出警告
+			if hookErr := runHooks(HookAfterRun, hooks.withStatus(err), db.DB); hookErr != nil {
+				warnf("%s", hookErr)
+			}
+		}
 		if err != nil {
-			return err
+			dbResult.MarkNotRecorded()
+			dbResult.Error = err.Error()
+		}
+		notifyDatabaseFinished(dbResult, err)
+		if err != nil {
+			return withCode(ExitMigration, err)
+		}
+	}
+
+	return nil
+}
+
+// 检查待回滚的记录中哪些 migration 文件已经不存在，并按 policy 处理。
+// 返回实际要处理的记录，以及只需删除记录的 id 集合
+func checkMissingFiles(db *DBConnection, dbResult *DatabaseResult, migrations *Migrations, list []MigrationRec, policy MissingFilePolicy) ([]MigrationRec, map[uint]struct{}, error) {
+	prune := make(map[uint]struct{})
+
+	missing := make([]MigrationRec, 0)
+	for _, rec := range list {
+		if !migrations.Exists(rec.Migration) {
+			missing = append(missing, rec)
+		}
+	}
+	if len(missing) == 0 {
+		return list, prune, nil
+	}
+
+	describe := func(recs []MigrationRec) string {
+		lines := make([]string, 0, len(recs))
+		for _, rec := range recs {
+			lines = append(lines, fmt.Sprintf("  Batch[%d] %s", rec.Batch, rec.Migration))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	switch policy {
+	case MissingSkip:
+		filtered := make([]MigrationRec, 0, len(list))
+		for _, rec := range list {
+			if migrations.Exists(rec.Migration) {
+				filtered = append(filtered, rec)
+				continue
+			}
+			logger.Info(fmt.Sprintf("[%d] Batch[%d] %s file is missing, skip", rec.Id, rec.Batch, rec.Migration))
+			dbResult.AddMigration(rec.Migration, rec.Batch, StatusSkipped, "file_missing")
 		}
+		return filtered, prune, nil
+
+	case MissingStored:
+		noStored := make([]MigrationRec, 0)
+		for _, rec := range missing {
+			if !rec.DownSQL.Valid {
+				noStored = append(noStored, rec)
+			}
+		}
+		if len(noStored) > 0 {
+			return nil, nil, errorf(ExitDrift, "db[%s] has no stored rollback SQL for these migrations whose files are missing:\n%s",
+				db.Config.Label(), describe(noStored))
+		}
+		return list, prune, nil
+
+	case MissingPrune:
+		logger.Warn(fmt.Sprintf("db[%s] has applied migrations whose files are missing:\n%s", db.Config.Label(), describe(missing)))
+		answer, _ := input(fmt.Sprintf("Remove these %d record(s) from migrations without rolling them back? (yN): ", len(missing)))
+		if strings.ToLower(answer) != "y" && strings.ToLower(answer) != "yes" {
+			return nil, nil, withCode(ExitRefused, errors.New("rollback aborted"))
+		}
+		for _, rec := range missing {
+			prune[rec.Id] = struct{}{}
+		}
+		return list, prune, nil
+	}
+
+	return nil, nil, errorf(ExitDrift, "db[%s] has applied migrations whose files are missing:\n%s\nuse --missing skip|stored|prune to continue",
+		db.Config.Label(), describe(missing))
+}
+
+// 依赖待回滚 migration 的 migration 必须一起回滚，否则拒绝回滚
+func checkDependents(db *DBConnection, migrations *Migrations, recs, list []MigrationRec) error {
+	rollingBack := make(map[string]struct{}, len(list))
+	for _, rec := range list {
+		rollingBack[rec.Migration] = struct{}{}
 	}
 
+	lines := make([]string, 0)
+	for _, rec := range recs {
+		if _, exist := rollingBack[rec.Migration]; exist || !migrations.Exists(rec.Migration) {
+			continue
+		}
+		for _, dependency := range migrations.GetInfo(rec.Migration).DependsOn {
+			if _, exist := rollingBack[dependency]; exist {
+				lines = append(lines, fmt.Sprintf("  %s is still applied and depends on %s", rec.Migration, dependency))
+			}
+		}
+	}
+	if len(lines) > 0 {
+		return errorf(ExitRefused, "db[%s] cannot roll back migrations that applied ones depend on, nothing was rolled back:\n%s",
+			db.Config.Label(), strings.Join(lines, "\n"))
+	}
 	return nil
 }
 
+// 选择回滚 SQL：默认使用执行时保存在 migrations 表中的副本，
+// preferFile 为 true 时优先使用磁盘上的 _rollback.sql 文件
+func resolveDownSQL(rec MigrationRec, migration MigrationInfo, preferFile bool) (string, error) {
+	// 旧版本的记录没有保存回滚 SQL，只能读文件
+	if !rec.DownSQL.Valid {
+		err := migration.LoadSQLFile()
+		if err != nil {
+			return "", err
+		}
+		return migration.GetDownSQL(), nil
+	}
+
+	err := migration.LoadSQLFile()
+	if err != nil {
+		if preferFile {
+			return "", err
+		}
+		warnf("%s rollback file is unavailable (%s), using the stored SQL", rec.Migration, err)
+		return rec.DownSQL.String, nil
+	}
+
+	fileSQL := migration.GetDownSQL()
+	if strings.TrimSpace(fileSQL) != strings.TrimSpace(rec.DownSQL.String) {
+		source := "the stored SQL"
+		if preferFile {
+			source = "the file on disk"
+		}
+		warnf("%s rollback file differs from the SQL stored when it was applied, using %s", rec.Migration, source)
+	}
+
+	if preferFile {
+		return fileSQL, nil
+	}
+	return rec.DownSQL.String, nil
+}
+
 func input(prompt string) (string, error) {
-	fmt.Print(prompt)
+	// json 模式下 stdout 只输出结果，提示信息写到 stderr
+	if outputFormat == OutputJSON {
+		fmt.Fprint(os.Stderr, prompt)
+	} else {
+		fmt.Print(prompt)
+	}
 	input, err := reader.ReadString('\n')
 	return strings.Trim(input, " \n"), err
 }
 
 func isBlueprintRepo(workDir string) (bool, error) {
-	_, err := os.Stat(path.Join(workDir, BlueprintConfigFileName))
+	_, err := os.Stat(configPath(workDir))
 	if err == nil {
 		return true, nil
 	}
@@ -415,25 +957,74 @@ func isEmptyRepo(workDir string) (bool, error) {
 		return false, fmt.Errorf("%s is not a dir", workDir)
 	}
 
-	// 读取目录下的文件
-	entries, err := os.ReadDir(workDir)
+	// 检查目录及子目录下是否有 .sql 文件
+	migrations, err := LoadMigrations(workDir, config.Hooks)
 	if err != nil {
 		return false, err
 	}
 
-	// 检查目录下是否有 .sql 文件
-	for _, entry := range entries {
-		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".sql" {
-			return false, nil
-		}
+	return len(migrations.GetNames()) == 0, nil
+}
+
+// 创建新文件，文件已存在时返回错误而不是覆盖
+func createFile(filename, content string) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
 
-	return true, nil
+	_, err = f.WriteString(content)
+	return err
 }
 
-func getMigrationFilename(note string) (name, rollbackName string) {
-	now := time.Now()
-	name = fmt.Sprintf("%s_%s.sql", now.Format("200601021504"), note)
-	rollbackName = fmt.Sprintf("%s_%s_rollback.sql", now.Format("200601021504"), note)
+// 生成新 migration 的文件名
+type migrationNamer struct {
+	scheme    NamingScheme
+	lastSeq   uint64
+	localTime bool            // 旧版本用本地时间生成 12 位时间戳，目录中有这样的 migration 时沿用本地时间
+	used      map[uint64]bool // 已被占用的版本号
+}
+
+func newMigrationNamer(migrationPath string, scheme NamingScheme) (*migrationNamer, error) {
+	namer := &migrationNamer{scheme: scheme, used: make(map[uint64]bool)}
+
+	migrations, err := LoadMigrations(migrationPath, config.Hooks)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range migrations.GetNames() {
+		version := MigrationVersion(name)
+		namer.used[version] = true
+		if len(versionPrefix(name)) == 12 {
+			namer.localTime = true
+		}
+		// 序号模式下从已有的最大序号开始递增
+		if isSequenceVersion(name) && version > namer.lastSeq {
+			namer.lastSeq = version
+		}
+	}
+	return namer, nil
+}
+
+func (n *migrationNamer) Filename(note string) (name, rollbackName string) {
+	var version string
+	if n.scheme == NamingSequence {
+		n.lastSeq++
+		version = fmt.Sprintf("%06d", n.lastSeq)
+	} else {
+		now := time.Now().UTC()
+		if n.localTime {
+			now = time.Now()
+		}
+		// 同一秒内创建的 migration 版本号相同，顺延到下一个没有被占用的秒
+		for n.used[MigrationVersion(now.Format("20060102150405"))] {
+			now = now.Add(time.Second)
+		}
+		version = now.Format("20060102150405")
+	}
+	n.used[MigrationVersion(version)] = true
+	name = fmt.Sprintf("%s_%s.sql", version, note)
+	rollbackName = fmt.Sprintf("%s_%s_rollback.sql", version, note)
 	return
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"database/sql"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 替换全局的 config、result 和日志输出，测试结束后恢复
+func setupCommandTest(t *testing.T, command string) {
+	savedConfig, savedResult, savedOut := config, result, logger.out
+	t.Cleanup(func() {
+		config, result, logger.out = savedConfig, savedResult, savedOut
+	})
+	config = Config{Env: "dev"}
+	result = &CommandResult{Command: command}
+	logger.out = io.Discard
+}
+
+// 打开 dir 下的 sqlite 数据库，执行 setup 中的语句
+func openTestSQLite(t *testing.T, dir string, setup ...string) *DBConnection {
+	t.Helper()
+	driver := SQLiteDriver{}
+	db, err := driver.Connect("", 0, "", "", filepath.Join(dir, "app.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, statement := range setup {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("%s: %s", statement, err)
+		}
+	}
+	return &DBConnection{DB: db, Driver: driver, Config: DBConfig{Type: SQLite, File: "./app.db"}}
+}
+
+func TestRollbackLegacyMigrationsTable(t *testing.T) {
+	setupCommandTest(t, "rollback")
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"000001_create_users.sql":          "CREATE TABLE users (id INT);",
+		"000001_create_users_rollback.sql": "DROP TABLE users;",
+	})
+	// 旧版本创建的 migrations 表没有 down_sql 字段
+	db := openTestSQLite(t, dir,
+		"CREATE TABLE migrations (id INTEGER PRIMARY KEY AUTOINCREMENT, migration VARCHAR(255) NOT NULL, batch INTEGER NOT NULL)",
+		"INSERT INTO migrations (migration, batch) VALUES ('000001_create_users', 1)",
+		"CREATE TABLE users (id INT)",
+	)
+
+	err := rollbackMigration(dir, []*DBConnection{db}, 0, 0, false, MissingError)
+	if err != nil {
+		t.Fatalf("rollback on a legacy table: %s (exit code %d)", err, exitCode(err))
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM migrations").Scan(&count)
+	if err != nil || count != 0 {
+		t.Errorf("migrations left = %d, %v, want 0", count, err)
+	}
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'users'").Scan(&count)
+	if err != nil || count != 0 {
+		t.Errorf("users table still exists (%v)", err)
+	}
+	var downSQL sql.NullString
+	err = db.QueryRow("SELECT down_sql FROM migrations").Scan(&downSQL)
+	if err != sql.ErrNoRows {
+		t.Errorf("down_sql column was not added: %v", err)
+	}
+}
+
+func TestRunMarksMigrationFailedWhenRecordFails(t *testing.T) {
+	setupCommandTest(t, "run")
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"000001_create_users.sql": "CREATE TABLE users (id INT);"})
+	// 语句执行成功，写入 migrations 表时失败
+	db := openTestSQLite(t, dir,
+		"CREATE TABLE migrations (id INTEGER PRIMARY KEY AUTOINCREMENT, migration VARCHAR(255) NOT NULL, batch INTEGER NOT NULL, down_sql TEXT NULL)",
+		"CREATE TRIGGER refuse_record BEFORE INSERT ON migrations BEGIN SELECT RAISE(ABORT, 'read only'); END",
+	)
+
+	err := runMigration(dir, []*DBConnection{db}, "")
+	if exitCode(err) != ExitMigration {
+		t.Fatalf("err = %v (exit code %d), want a migration error", err, exitCode(err))
+	}
+	migrations := result.Databases[0].Migrations
+	if len(migrations) != 1 || migrations[0].Status != StatusFailed {
+		t.Fatalf("migrations = %+v, want one failed migration", migrations)
+	}
+	if want := "record migration failed: read only"; migrations[0].Error != want {
+		t.Errorf("error = %q, want %q", migrations[0].Error, want)
+	}
+}
+
+func TestMigrationNamer(t *testing.T) {
+	setupCommandTest(t, "create")
+
+	t.Run("sequence continues after the highest one", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTestFiles(t, dir, map[string]string{
+			"000007_a.sql":                 "",
+			"sub/000041_b.sql":             "",
+			"20241118165301_timestamp.sql": "",
+		})
+		namer, err := newMigrationNamer(dir, NamingSequence)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"000042_next.sql", "000043_next.sql"} {
+			if name, rbName := namer.Filename("next"); name != want || rbName != strings.TrimSuffix(want, ".sql")+"_rollback.sql" {
+				t.Errorf("Filename() = %s, %s, want %s", name, rbName, want)
+			}
+		}
+	})
+
+	t.Run("taken timestamps move to the next free second", func(t *testing.T) {
+		namer, err := newMigrationNamer(t.TempDir(), NamingTimestamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now().UTC()
+		for i := 0; i < 3; i++ {
+			namer.used[MigrationVersion(now.Add(time.Duration(i)*time.Second).Format("20060102150405"))] = true
+		}
+		seen := make(map[uint64]bool)
+		for i := 0; i < 3; i++ {
+			name, _ := namer.Filename("x")
+			version := MigrationVersion(name)
+			if len(versionPrefix(name)) != 14 || version < MigrationVersion(now.Add(3*time.Second).Format("20060102150405")) || seen[version] {
+				t.Errorf("Filename() = %s, want a free second-precision version after %s", name, now.Format("20060102150405"))
+			}
+			seen[version] = true
+		}
+	})
+
+	t.Run("minute precision versions keep the local clock", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTestFiles(t, dir, map[string]string{"202411181653_legacy.sql": ""})
+		namer, err := newMigrationNamer(dir, NamingTimestamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !namer.localTime {
+			t.Error("namer does not use the local clock")
+		}
+		if !namer.used[20241118165300] {
+			t.Error("the legacy version is not taken")
+		}
+	})
+}
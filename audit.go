@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 会修改表结构、migration 记录或数据的命令，执行后写审计记录
+var auditedCommands = map[string]bool{
+	"run":            true,
+	"rollback":       true,
+	"import-history": true,
+	"seed":           true,
+}
+
+// 一条审计记录，对应一个命令在一个数据库上的执行
+type AuditRecord struct {
+	Time        string           `json:"time"`
+	User        string           `json:"user"`
+	Host        string           `json:"host"`
+	Env         string           `json:"env"`
+	Database    string           `json:"database"`
+	Command     string           `json:"command"`
+	CommandLine string           `json:"command_line"`
+	Migrations  []AuditMigration `json:"migrations"`
+	Outcome     string           `json:"outcome"` // success/failed
+	Error       string           `json:"error,omitempty"`
+}
+
+type AuditMigration struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Checksum string `json:"checksum,omitempty"` // 执行的 SQL 的 sha256
+}
+
+// 计算 SQL 的 sha256
+func sqlChecksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// 根据命令的执行结果写审计记录，记录只追加，不会被改写
+func writeAudit(workDir string, cmdErr error) {
+	if config.Audit == nil || !auditedCommands[result.Command] || result.DryRun {
+		return
+	}
+	if config.Audit.File == "" && !config.Audit.Table {
+		return
+	}
+
+	records := buildAuditRecords(cmdErr)
+
+	if config.Audit.File != "" {
+		filename := config.Audit.File
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(workDir, filename)
+		}
+		err := appendAuditFile(filename, records)
+		if err != nil {
+			warnf("write audit file %s failed: %s", filename, err)
+		}
+	}
+
+	if config.Audit.Table {
+		for idx, dbResult := range result.Databases {
+			if dbResult.conn == nil {
+				continue
+			}
+			err := insertAuditRecord(dbResult.conn, records[idx])
+			if err != nil {
+				warnf("write audit table of db[%s] failed: %s", dbResult.Database, err)
+			}
+		}
+	}
+}
+
+func buildAuditRecords(cmdErr error) []AuditRecord {
+	osUser := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		osUser = u.Username
+	}
+	host, _ := os.Hostname()
+
+	base := AuditRecord{
+		Time:        time.Now().Format(time.RFC3339Nano),
+		User:        osUser,
+		Host:        host,
+		Env:         config.Env,
+		Command:     result.Command,
+		CommandLine: logger.Redact(strings.Join(os.Args, " ")),
+		Migrations:  make([]AuditMigration, 0),
+		Outcome:     "success",
+	}
+
+	// 还没处理到任何数据库就失败了，也要留下记录
+	if len(result.Databases) == 0 {
+		record := base
+		if cmdErr != nil {
+			record.Outcome, record.Error = "failed", logger.Redact(cmdErr.Error())
+		}
+		return []AuditRecord{record}
+	}
+
+	records := make([]AuditRecord, 0, len(result.Databases))
+	for _, dbResult := range result.Databases {
+		record := base
+		record.Database = dbResult.Database
+		record.Migrations = make([]AuditMigration, 0)
+		for _, m := range dbResult.Migrations {
+			if m.Status == StatusSkipped || m.Status == StatusPending || m.Status == StatusNotApplicable {
+				continue
+			}
+			record.Migrations = append(record.Migrations, AuditMigration{
+				Name:     m.Name,
+				Status:   m.Status,
+				Checksum: m.Checksum,
+			})
+		}
+		if errText := result.DatabaseError(dbResult, cmdErr); errText != "" {
+			record.Outcome, record.Error = "failed", errText
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func appendAuditFile(filename string, records []AuditRecord) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(append(line, '\n'))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertAuditRecord(db *DBConnection, record AuditRecord) error {
+	err := db.Driver.CheckAuditTable(db.DB)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return db.Driver.InsertAuditRecord(db.DB, record, string(content))
+}
@@ -0,0 +1,596 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 子命令，Setup 定义命令自己的选项并返回执行命令的函数
+type Command struct {
+	Name     string
+	Aliases  []string
+	Args     string // 位置参数，用于帮助信息，如 [table]
+	Summary  string
+	Default  bool // 没有指定命令时执行
+	Hidden   bool // 不在帮助信息中显示
+	NoBanner bool // 输出供 shell 等程序使用，不显示 banner
+	Setup    func(fs *flag.FlagSet) func(args []string) error
+}
+
+var commands []*Command
+
+// 全局选项
+var (
+	configFile string   // --config，默认为仓库目录下的 blueprint.json
+	dirOption  string   // --dir，migration 仓库目录，默认为当前目录
+	envName    string   // --env，覆盖配置中的 env
+	dbAliases  []string // --db，只使用这些数据库
+)
+
+// 有短写形式的全局选项
+var shorthands = map[string]string{
+	"quiet":   "q",
+	"verbose": "v",
+}
+
+func init() {
+	commands = []*Command{
+		{
+			Name:    "init",
+			Summary: "Init a Blueprint repo in current work directory",
+			Setup: func(fs *flag.FlagSet) func(args []string) error {
+				return func(args []string) error {
+					if err := noArgs(args); err != nil {
+						return err
+					}
+					return initBlueprint(repoDir)
+				}
+			},
+		},
+		{
+			Name:    "run",
+			Summary: "Exec migrations",
+			Default: true,
+			Setup: func(fs *flag.FlagSet) func(args []string) error {
+				var phase MigrationPhase
+				fs.Func("phase", "only run the migrations of `phase` pre/post, default both", func(value string) error {
+					phase = MigrationPhase(value)
+					if !phase.IsValid() {
+						return errors.New("must be one of pre, post")
+					}
+					return nil
+				})
+				fs.Func("report", "write a run report, `file` is .md or .html", setReportFile)
+				return func(args []string) error {
+					if err := noArgs(args); err != nil {
+						return err
+					}
+					bootstrap(repoDir)
+					return runMigration(repoDir, dbs, phase)
+				}
+			},
+		},
+		{
+			Name:    "status",
+			Args:    "[migration...]",
+			Summary: "Show applied and pending migrations of each database",
+			Setup: func(fs *flag.FlagSet) func(args []string) error {
+				return func(args []string) error {
+					bootstrap(repoDir)
+					return showStatus(repoDir, dbs, args)
+				}
+			},
+		},
+		{
+			Name:    "create",
+			Aliases: []string{"update"},
+			Args:    "[table]",
+			Summary: "Create a pair(include rollback) migration sql files",
+			Setup: func(fs *flag.FlagSet) func(args []string) error {
+				irreversible := fs.Bool("irreversible", false, "only create the migration file, without rollback")
+				subDir := fs.String("path", "", "create the files in a sub `dir` of the repo")
+				singleFile := fs.Bool("single-file", false, "put up and down SQL in one file, separated by\n-- +blueprint Up / -- +blueprint Down")
+				return func(args []string) error {
+					if len(args) > 1 {
+						return usageError("unexpected argument: " + args[1])
+					}
+					tableName := ""
+					if len(args) == 1 {
+						tableName = args[0]
+					}
+					return createMigration(repoDir, result.Command, tableName, *subDir, *irreversible, *singleFile)
+				}
+			},
+		},
+		{
+			Name:    "dump",
+			Summary: "Dump schema from database",
+			Setup: func(fs *flag.FlagSet) func(args []string) error {
+				force := fs.Bool("force", false, "dump even if the repository already has migrations")
+				return func(args []string) error {
+					if err := noArgs(args); err != nil {
+						return err
+					}
+					bootstrap(repoDir)
+					if len(dbs) == 0 {
+						return withCode(ExitConfig, errors.New("no database to dump"))
+					}
+					return dumpSchemas(dbs[0], repoDir, *force)
+				}
+			},
+		},
+		{
+			Name:    "rollback",
+			Summary: "Rollback, default is --batch 1",
+			Setup: func(fs *flag.FlagSet) func(args []string) error {
+				step, batch := 0, 0
+				fs.Func("step", "roll back the last `n` migration(s)", positiveInt(&step))
+				fs.Func("batch", "roll back the last `n` batch(es), only one of --step or --batch can be specified", positiveInt(&batch))
+				preferFile := fs.Bool("prefer-file", false, "use the _rollback.sql file on disk instead of the SQL\nstored when the migration was applied")
+				missingPolicy := MissingError
+				fs.Func("missing", "what to do with applied migrations whose files are missing:\n`policy` is error(default)/skip/stored/prune", func(value string) error {
+					missingPolicy = MissingFilePolicy(value)
+					if !missingPolicy.IsValid() {
+						return errors.New("must be one of error, skip, stored, prune")
+					}
+					return nil
+				})
+				fs.Func("report", "write a rollback report, `file` is .md or .html", setReportFile)
+				return func(args []string) error {
+					if err := noArgs(args); err != nil {
+						return err
+					}
+					if step != 0 && batch != 0 {
+						return usageError("only one of --step or --batch can be specified at a time")
+					}
+					bootstrap(repoDir)
+					return rollbackMigration(repoDir, dbs, step, batch, *preferFile, missingPolicy)
+				}
+			},
+		},
+		{
+			Name:    "import-history",
+			Summary: "Import applied migrations from another tool's history table",
+			Setup: func(fs *flag.FlagSet) func(args []string) error {
+				source := fs.String("from", "", "the `tool`: laravel/golang-migrate/goose/flyway")
+				table := fs.String("table", "", "`name` of the history table, if not the tool's default")
+				dryRun := fs.Bool("dry-run", false, "only show what would be imported")
+				return func(args []string) error {
+					if err := noArgs(args); err != nil {
+						return err
+					}
+					if !HistorySource(*source).IsValid() {
+						return usageError("--from must be one of laravel, golang-migrate, goose, flyway")
+					}
+					bootstrap(repoDir)
+					return importHistory(repoDir, dbs, HistorySource(*source), *table, *dryRun)
+				}
+			},
+		},
+		{
+			Name:    "convert",
+			Args:    "<dir>",
+			Summary: "Convert another tool's migration files into Blueprint's layout",
+			Setup: func(fs *flag.FlagSet) func(args []string) error {
+				source := fs.String("from", "", "the `tool`: golang-migrate/goose/flyway")
+				return func(args []string) error {
+					if len(args) != 1 {
+						return usageError("usage: blueprint convert --from golang-migrate|goose|flyway <dir>")
+					}
+					return convertMigrations(repoDir, HistorySource(*source), args[0])
+				}
+			},
+		},
+		{
+			Name:    "seed",
+			Args:    "[name]",
+			Summary: "Run seeders and load fixtures from the seeds directory",
+			Setup: func(fs *flag.FlagSet) func(args []string) error {
+				force := fs.Bool("force", false, "run all seeds, including unchanged ones that had run")
+				truncate := fs.Bool("truncate", false, "empty the table of a csv/json fixture before loading it")
+				return func(args []string) error {
+					if len(args) > 1 {
+						return usageError("unexpected argument: " + args[1])
+					}
+					name := ""
+					if len(args) == 1 {
+						name = args[0]
+					}
+					bootstrap(repoDir)
+					return runSeeds(repoDir, dbs, name, *force, *truncate)
+				}
+			},
+		},
+		{
+			Name:     "graph",
+			Args:     "[migration]",
+			Summary:  "Print the dependency graph of migrations",
+			NoBanner: true,
+			Setup: func(fs *flag.FlagSet) func(args []string) error {
+				format := GraphDOT
+				fs.Func("format", "`format` of the graph: dot(default)/mermaid", func(value string) error {
+					format = GraphFormat(value)
+					if !format.IsValid() {
+						return errors.New("must be one of dot, mermaid")
+					}
+					return nil
+				})
+				all := fs.Bool("all", false, "include migrations without dependencies")
+				return func(args []string) error {
+					if len(args) > 1 {
+						return usageError("unexpected argument: " + args[1])
+					}
+					focus := ""
+					if len(args) == 1 {
+						focus = args[0]
+					}
+					return showGraph(repoDir, format, *all, focus)
+				}
+			},
+		},
+		{
+			Name:     "completion",
+			Args:     "bash|zsh|fish",
+			Summary:  "Print the shell completion script",
+			NoBanner: true,
+			Setup: func(fs *flag.FlagSet) func(args []string) error {
+				return func(args []string) error {
+					if len(args) != 1 {
+						return usageError("usage: blueprint completion bash|zsh|fish")
+					}
+					return echoCompletion(args[0])
+				}
+			},
+		},
+		{
+			Name:     "__complete",
+			Args:     "-- <words>",
+			Summary:  "Print completion candidates for the words of a command line",
+			Hidden:   true,
+			NoBanner: true,
+			Setup: func(fs *flag.FlagSet) func(args []string) error {
+				return runComplete
+			},
+		},
+		{
+			Name:    "help",
+			Args:    "[command]",
+			Summary: "Display this infomation",
+			Setup: func(fs *flag.FlagSet) func(args []string) error {
+				return func(args []string) error {
+					if outputFormat == OutputJSON {
+						return nil
+					}
+					if len(args) == 0 {
+						echoHelp()
+						return nil
+					}
+					cmd := findCommand(args[0])
+					if cmd == nil {
+						return usageError("unknown command: " + args[0])
+					}
+					echoCommandHelp(cmd)
+					return nil
+				}
+			},
+		},
+	}
+}
+
+func findCommand(name string) *Command {
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			return cmd
+		}
+		for _, alias := range cmd.Aliases {
+			if alias == name {
+				return cmd
+			}
+		}
+	}
+	return nil
+}
+
+func noArgs(args []string) error {
+	if len(args) > 0 {
+		return usageError("unexpected argument: " + args[0])
+	}
+	return nil
+}
+
+func positiveInt(p *int) func(string) error {
+	return func(value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return errors.New("must be a positive integer")
+		}
+		*p = n
+		return nil
+	}
+}
+
+func setReportFile(value string) error {
+	err := checkReportFile(value)
+	if err != nil {
+		return err
+	}
+	reportFile = value
+	return nil
+}
+
+// 注册全局选项，全局选项可以写在命令之前或之后
+func addGlobalFlags(fs *flag.FlagSet) {
+	fs.Func("output", "output `format`: text/json, json prints one result document per command", func(value string) error {
+		outputFormat = OutputFormat(value)
+		if !outputFormat.IsValid() {
+			outputFormat = OutputText
+			return errors.New("must be one of text, json")
+		}
+		return nil
+	})
+	fs.Func("log-format", "`format` of log lines: text(default)/logfmt/json, logfmt and json lines have timestamps", func(value string) error {
+		if !LogFormat(value).IsValid() {
+			return errors.New("must be one of text, logfmt, json")
+		}
+		logger.format = LogFormat(value)
+		return nil
+	})
+	setLevel := func(level LogLevel) func(string) error {
+		return func(string) error {
+			logger.level = level
+			return nil
+		}
+	}
+	fs.BoolFunc("quiet", "only print warnings and errors", setLevel(LevelWarn))
+	fs.BoolFunc("q", "", setLevel(LevelWarn))
+	fs.BoolFunc("verbose", "print more details, e.g. database connections", setLevel(LevelVerbose))
+	fs.BoolFunc("v", "", setLevel(LevelVerbose))
+	fs.BoolFunc("debug", "print every statement sent to the databases", setLevel(LevelDebug))
+	fs.BoolFunc("no-banner", "do not print the banner", func(string) error {
+		showBanner = false
+		return nil
+	})
+	fs.StringVar(&metricsFile, "metrics-file", metricsFile, "write Prometheus metrics of run/rollback/status to a textfile `file`")
+	fs.StringVar(&pushgatewayURL, "pushgateway", pushgatewayURL, "push the same metrics to a Prometheus pushgateway `url`")
+	fs.StringVar(&configFile, "config", configFile, "config `file`, default blueprint.json in the migrations dir")
+	fs.StringVar(&dirOption, "dir", dirOption, "migrations `dir`, default the current directory")
+	fs.StringVar(&envName, "env", envName, "`name` of the environment, reads blueprint.<name>.json if it exists")
+	fs.Func("db", "only use the database with this `alias`, repeatable or comma separated", func(value string) error {
+		for _, alias := range strings.Split(value, ",") {
+			if alias = strings.TrimSpace(alias); alias != "" {
+				dbAliases = append(dbAliases, alias)
+			}
+		}
+		return nil
+	})
+}
+
+func newCommandFlagSet(cmd *Command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// 解析选项，选项和位置参数可以交替出现，-- 之后的都是位置参数
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0)
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// 解析命令行并执行命令
+func runCommandLine(args []string) error {
+	// 命令之前的全局选项
+	global := flag.NewFlagSet("blueprint", flag.ContinueOnError)
+	global.SetOutput(io.Discard)
+	addGlobalFlags(global)
+	err := global.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return runHelp()
+	}
+	if err != nil {
+		return usageError(flagError(err) + " (see blueprint help)")
+	}
+
+	args = global.Args()
+	var cmd *Command
+	if len(args) == 0 {
+		for _, c := range commands {
+			if c.Default {
+				cmd = c
+			}
+		}
+		result.Command = cmd.Name
+	} else {
+		cmd = findCommand(strings.ToLower(args[0]))
+		if cmd == nil {
+			return usageError("unknown command: " + args[0] + " (see blueprint help)")
+		}
+		result.Command = strings.ToLower(args[0])
+		args = args[1:]
+	}
+
+	fs := newCommandFlagSet(cmd)
+	run := cmd.Setup(fs)
+	addGlobalFlags(fs)
+	positional, err := parseFlags(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		result.Command = "help"
+		err = applyGlobalOptions()
+		if err == nil && outputFormat != OutputJSON {
+			echoCommandHelp(cmd)
+		}
+		return err
+	}
+	if err != nil {
+		return usageError(fmt.Sprintf("%s (see blueprint %s --help)", flagError(err), cmd.Name))
+	}
+
+	err = applyGlobalOptions()
+	if err != nil {
+		return err
+	}
+	if showBanner && !cmd.NoBanner {
+		echoVersion()
+	}
+	return run(positional)
+}
+
+// flag 包的错误信息中选项只有一个 -，统一成帮助信息中的写法
+func flagError(err error) string {
+	return strings.NewReplacer(
+		"flag provided but not defined: -", "unknown flag: --",
+		"for flag -", "for flag --",
+		"flag needs an argument: -", "flag needs an argument: --",
+	).Replace(err.Error())
+}
+
+func runHelp() error {
+	result.Command = "help"
+	err := applyGlobalOptions()
+	if err == nil && outputFormat != OutputJSON {
+		echoHelp()
+	}
+	return err
+}
+
+// 全局选项解析完成后生效
+func applyGlobalOptions() error {
+	// json 模式下 stdout 只输出结果文档，日志写到 stderr
+	if outputFormat == OutputJSON {
+		logger.out = os.Stderr
+	}
+	// banner 会破坏结构化输出，只在普通文本模式下显示
+	if outputFormat == OutputJSON || logger.format != LogText || logger.level > LevelInfo {
+		showBanner = false
+	}
+
+	if dirOption == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("get cwd failed: %s", err.Error())
+		}
+		repoDir = cwd
+		return nil
+	}
+	dir, err := filepath.Abs(dirOption)
+	if err != nil {
+		return withCode(ExitUsage, err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return usageError("--dir is not a directory: " + dirOption)
+	}
+	repoDir = dir
+	return nil
+}
+
+func echoHelp() {
+	fmt.Println(`Usage: blueprint [global options] <command> [options] [args]`)
+	fmt.Println()
+	fmt.Println(`Commands:`)
+	for _, cmd := range commands {
+		if cmd.Hidden {
+			continue
+		}
+		name := strings.Join(append([]string{cmd.Name}, cmd.Aliases...), ", ")
+		summary := cmd.Summary
+		if cmd.Default {
+			summary += " (default)"
+		}
+		fmt.Printf("  %-18s  %s\n", name, summary)
+	}
+	fmt.Println()
+	fmt.Println(`Global options:`)
+	fs := flag.NewFlagSet("blueprint", flag.ContinueOnError)
+	addGlobalFlags(fs)
+	echoFlags(fs, nil)
+	fmt.Println()
+	fmt.Println(`Run 'blueprint <command> --help' for the options of a command.`)
+}
+
+// 输出命令的帮助信息
+func echoCommandHelp(cmd *Command) {
+	fs := newCommandFlagSet(cmd)
+	cmd.Setup(fs)
+	own := make(map[string]bool)
+	fs.VisitAll(func(f *flag.Flag) { own[f.Name] = true })
+
+	usage := "blueprint " + cmd.Name
+	if len(own) > 0 {
+		usage += " [options]"
+	}
+	if cmd.Args != "" {
+		usage += " " + cmd.Args
+	}
+	fmt.Println("Usage: " + usage)
+	fmt.Println()
+	fmt.Println(cmd.Summary)
+	if len(cmd.Aliases) > 0 {
+		fmt.Println("Aliases: " + strings.Join(cmd.Aliases, ", "))
+	}
+	if len(own) > 0 {
+		fmt.Println()
+		fmt.Println("Options:")
+		echoFlags(fs, own)
+	}
+	fmt.Println()
+	fmt.Println("Run 'blueprint help' for the global options.")
+}
+
+// 输出选项，only 不为 nil 时只输出其中的选项
+func echoFlags(fs *flag.FlagSet, only map[string]bool) {
+	short := make(map[string]bool)
+	for _, s := range shorthands {
+		short[s] = true
+	}
+
+	// VisitAll 按名称顺序遍历
+	type line struct{ name, usage string }
+	lines := make([]line, 0)
+	fs.VisitAll(func(f *flag.Flag) {
+		if (only != nil && !only[f.Name]) || short[f.Name] {
+			return
+		}
+		argName, usage := flag.UnquoteUsage(f)
+		name := "--" + f.Name
+		if s, ok := shorthands[f.Name]; ok {
+			name = "-" + s + ", " + name
+		}
+		if argName != "" {
+			name += " " + argName
+		}
+		lines = append(lines, line{name: name, usage: usage})
+	})
+	for _, l := range lines {
+		usage := strings.Split(l.usage, "\n")
+		if len(l.name) > 22 {
+			fmt.Printf("  %s\n", l.name)
+			fmt.Printf("  %-22s  %s\n", "", usage[0])
+		} else {
+			fmt.Printf("  %-22s  %s\n", l.name, usage[0])
+		}
+		for _, more := range usage[1:] {
+			fmt.Printf("  %-22s  %s\n", "", more)
+		}
+	}
+}
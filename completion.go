@@ -0,0 +1,286 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 各 shell 的补全脚本，候选项由隐藏命令 __complete 给出，没有候选项时补全文件名
+const bashCompletion = `# bash completion for blueprint
+_blueprint() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    local IFS=$'\n'
+    COMPREPLY=($(compgen -W "$(blueprint __complete -- "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null)" -- "$cur"))
+}
+complete -o default -F _blueprint blueprint
+`
+
+const zshCompletion = `#compdef blueprint
+# zsh completion for blueprint
+_blueprint() {
+    local -a candidates
+    candidates=("${(@f)$(blueprint __complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    if [[ -n "${candidates[1]}" ]]; then
+        compadd -- "${candidates[@]}"
+    else
+        _files
+    fi
+}
+compdef _blueprint blueprint
+`
+
+const fishCompletion = `# fish completion for blueprint
+function __blueprint_complete
+    set -l tokens (commandline -opc) (commandline -ct)
+    set -l candidates (blueprint __complete -- $tokens[2..-1] 2>/dev/null)
+    if test (count $candidates) -gt 0
+        printf '%s\n' $candidates
+    else
+        __fish_complete_path (commandline -ct)
+    end
+end
+complete -c blueprint -f -a '(__blueprint_complete)'
+`
+
+// 输出补全脚本
+func echoCompletion(shell string) error {
+	switch shell {
+	case "bash":
+		echof("%s", bashCompletion)
+	case "zsh":
+		echof("%s", zshCompletion)
+	case "fish":
+		echof("%s", fishCompletion)
+	default:
+		return usageError("usage: blueprint completion bash|zsh|fish")
+	}
+	return nil
+}
+
+// 根据已输入的词给出补全候选项，最后一个词是正在输入的词
+func completeWords(words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current := words[len(words)-1]
+	words = words[:len(words)-1]
+
+	global := flag.NewFlagSet("blueprint", flag.ContinueOnError)
+	addGlobalFlags(global)
+
+	// 找出命令，以及正在输入的是否为某个选项的值
+	var cmd *Command
+	var fs *flag.FlagSet
+	valueOf := ""
+	for idx := 0; idx < len(words); idx++ {
+		word := words[idx]
+		if word == "--" {
+			break
+		}
+		if strings.HasPrefix(word, "-") {
+			name, value, hasValue := strings.Cut(strings.TrimLeft(word, "-"), "=")
+			f := lookupFlag(global, fs, name)
+			if f == nil || hasValue || isBoolFlag(f) {
+				if f != nil && hasValue {
+					applyCompletionFlag(name, value)
+				}
+				continue
+			}
+			if idx+1 == len(words) {
+				valueOf = name
+				break
+			}
+			applyCompletionFlag(name, words[idx+1])
+			idx++
+			continue
+		}
+		if cmd == nil {
+			cmd = findCommand(strings.ToLower(word))
+			if cmd == nil {
+				return nil
+			}
+			fs = newCommandFlagSet(cmd)
+			cmd.Setup(fs)
+		}
+	}
+
+	var candidates []string
+	switch {
+	case valueOf != "":
+		candidates = completeFlagValue(cmd, valueOf)
+	case strings.HasPrefix(current, "-"):
+		candidates = completeFlagNames(global, fs)
+	case cmd == nil:
+		for _, c := range commands {
+			if !c.Hidden {
+				candidates = append(candidates, c.Name)
+				candidates = append(candidates, c.Aliases...)
+			}
+		}
+	case cmd.Name == "help":
+		for _, c := range commands {
+			if !c.Hidden {
+				candidates = append(candidates, c.Name)
+			}
+		}
+	case cmd.Name == "completion":
+		candidates = []string{"bash", "zsh", "fish"}
+	case cmd.Name == "seed":
+		candidates = completeSeeds()
+	case cmd.Name == "status" || cmd.Name == "graph":
+		candidates = completeMigrations()
+	}
+
+	matched := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, current) {
+			matched = append(matched, candidate)
+		}
+	}
+	return matched
+}
+
+func lookupFlag(global, fs *flag.FlagSet, name string) *flag.Flag {
+	if fs != nil {
+		if f := fs.Lookup(name); f != nil {
+			return f
+		}
+	}
+	return global.Lookup(name)
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// 补全时只关心决定读取哪个配置的全局选项
+func applyCompletionFlag(name, value string) {
+	switch name {
+	case "dir":
+		dirOption = value
+	case "config":
+		configFile = value
+	case "env":
+		envName = value
+	}
+}
+
+func completeFlagNames(global, fs *flag.FlagSet) []string {
+	short := make(map[string]bool)
+	for _, s := range shorthands {
+		short[s] = true
+	}
+	names := make([]string, 0)
+	add := func(f *flag.Flag) {
+		if !short[f.Name] {
+			names = append(names, "--"+f.Name)
+		}
+	}
+	if fs != nil {
+		fs.VisitAll(add)
+	}
+	global.VisitAll(add)
+	names = append(names, "--help")
+	return names
+}
+
+// 选项的可选值，返回 nil 时由 shell 补全文件名
+func completeFlagValue(cmd *Command, name string) []string {
+	switch name {
+	case "output":
+		return []string{string(OutputText), string(OutputJSON)}
+	case "log-format":
+		return []string{string(LogText), string(LogLogfmt), string(LogJSON)}
+	case "missing":
+		return []string{string(MissingError), string(MissingSkip), string(MissingStored), string(MissingPrune)}
+	case "from":
+		sources := []string{string(SourceGolangMigrate), string(SourceGoose), string(SourceFlyway)}
+		if cmd != nil && cmd.Name == "import-history" {
+			sources = append([]string{string(SourceLaravel)}, sources...)
+		}
+		return sources
+	case "phase":
+		return []string{string(PhasePre), string(PhasePost)}
+	case "format":
+		return []string{string(GraphDOT), string(GraphMermaid)}
+	case "db":
+		return completeDatabases()
+	}
+	return nil
+}
+
+// 配置中数据库的别名，没有别名的用名称
+func completeDatabases() []string {
+	err := applyGlobalOptions()
+	if err != nil {
+		return nil
+	}
+	err = loadJsonConfig(repoDir)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(config.Databases))
+	for _, db := range config.Databases {
+		switch {
+		case db.Alias != "":
+			names = append(names, db.Alias)
+		case db.Type == SQLite:
+			names = append(names, db.File)
+		default:
+			names = append(names, db.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// seed 的名称，取决于配置中的 env
+func completeSeeds() []string {
+	err := applyGlobalOptions()
+	if err != nil {
+		return nil
+	}
+	err = loadJsonConfig(repoDir)
+	if err != nil {
+		return nil
+	}
+	seeds, err := LoadSeeds(repoDir, config.Env)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(seeds))
+	for _, seed := range seeds {
+		names = append(names, seed.Name)
+	}
+	return names
+}
+
+// 仓库中 migration 的名称，按执行顺序
+func completeMigrations() []string {
+	err := applyGlobalOptions()
+	if err != nil {
+		return nil
+	}
+	// 配置中的钩子文件不是 migration，读不到配置时也照样补全
+	_ = loadJsonConfig(repoDir)
+	migrations, err := LoadMigrations(repoDir, config.Hooks)
+	if err != nil {
+		return nil
+	}
+	return migrations.GetNames()
+}
+
+// __complete 的入口，输出每行一个候选项
+func runComplete(words []string) error {
+	if outputFormat == OutputJSON {
+		return errors.New("__complete does not support --output json")
+	}
+	for _, candidate := range completeWords(words) {
+		fmt.Println(candidate)
+	}
+	return nil
+}
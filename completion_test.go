@@ -0,0 +1,76 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// 创建一个包含配置、migration、钩子和 seed 的仓库目录
+func newCompletionRepo(t *testing.T) string {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		BlueprintConfigFileName: `{
+			"env": "staging",
+			"hooks": {"after_run": [{"sql": "hooks/analyze.sql"}]},
+			"databases": [
+				{"type": "sqlite", "file": "./app.db", "alias": "primary"},
+				{"type": "sqlite", "file": "./logs.db"},
+				{"type": "mysql", "name": "reports", "alias": "analytics"}
+			]
+		}`,
+		"000001_create_users.sql":                            "CREATE TABLE users (id INT);",
+		"000001_create_users_rollback.sql":                   "DROP TABLE users;",
+		"000002_create_posts.sql":                            "-- +blueprint depends-on: 000003_create_tags\nCREATE TABLE posts (id INT);",
+		"000003_create_tags.sql":                             "CREATE TABLE tags (id INT);",
+		"billing/000004_create_invoices.sql":                 "CREATE TABLE invoices (id INT);",
+		"hooks/analyze.sql":                                  "ANALYZE;",
+		filepath.Join(SeedsDirName, "01_users.csv"):          "id\n1\n",
+		filepath.Join(SeedsDirName, "staging", "posts.json"): "[]",
+	})
+	return dir
+}
+
+func TestCompleteWords(t *testing.T) {
+	dir := newCompletionRepo(t)
+
+	savedConfig, savedRepoDir := config, repoDir
+	t.Cleanup(func() {
+		config, repoDir = savedConfig, savedRepoDir
+		dirOption, configFile, envName = "", "", ""
+	})
+
+	tests := []struct {
+		name  string
+		words []string
+		want  []string
+	}{
+		{"command prefix", []string{"st"}, []string{"status"}},
+		{"command alias", []string{"up"}, []string{"update"}},
+		{"flag names", []string{"rollback", "--mi"}, []string{"--missing"}},
+		{"flag values", []string{"run", "--phase", ""}, []string{"pre", "post"}},
+		{"flag value of a command", []string{"--dir", dir, "graph", "--format", "m"}, []string{"mermaid"}},
+		{"databases", []string{"--dir", dir, "status", "--db", ""}, []string{"./logs.db", "analytics", "primary"}},
+		{"migrations of status", []string{"--dir", dir, "status", ""},
+			[]string{"000001_create_users", "000003_create_tags", "000002_create_posts", "000004_create_invoices"}},
+		{"migrations after another one", []string{"--dir", dir, "status", "000001_create_users", "000003"}, []string{"000003_create_tags"}},
+		{"migration of graph", []string{"--dir=" + dir, "graph", "--format", "dot", "000002"}, []string{"000002_create_posts"}},
+		{"seeds of the env", []string{"--dir", dir, "seed", ""}, []string{"01_users", "staging/posts"}},
+		{"seeds of --env", []string{"--dir", dir, "--env", "dev", "seed", ""}, []string{"01_users"}},
+		{"unknown command", []string{"--dir", dir, "deploy", ""}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config = Config{}
+			dirOption, configFile, envName = "", "", ""
+
+			got := completeWords(tt.words)
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("completeWords(%q) = %q, want %q", tt.words, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 )
 
 type DBType string
@@ -30,26 +32,166 @@ type DBConfig struct {
 	Pass string `json:"pass"` // used by mysql, pg
 	Name string `json:"name"` // used by mysql, pg
 	File string `json:"file"` // used by sqlite
+
+	Alias string `json:"alias,omitempty"` // name for --db, e.g. primary
+}
+
+// Label returns a short description of the database for output
+func (c DBConfig) Label() string {
+	if c.Type == SQLite {
+		return fmt.Sprintf("%s %s", c.Type, c.File)
+	}
+	return fmt.Sprintf("%s %s:%d/%s", c.Type, c.Host, c.Port, c.Name)
+}
+
+// OutOfOrderPolicy decides what to do with pending migrations that sort
+// before the latest applied one
+type OutOfOrderPolicy string
+
+const (
+	OutOfOrderAllow OutOfOrderPolicy = "allow"
+	OutOfOrderWarn  OutOfOrderPolicy = "warn"
+	OutOfOrderDeny  OutOfOrderPolicy = "deny"
+)
+
+func (p OutOfOrderPolicy) IsValid() bool {
+	switch p {
+	case OutOfOrderAllow, OutOfOrderWarn, OutOfOrderDeny:
+		return true
+	}
+	return false
+}
+
+// NamingScheme decides how the version prefix of new migration files is generated
+type NamingScheme string
+
+const (
+	NamingTimestamp NamingScheme = "timestamp" // timestamp with second precision, e.g. 20241118165301; UTC unless the repository has 12-digit local ones
+	NamingSequence  NamingScheme = "sequence"  // increasing sequence number, e.g. 000042
+)
+
+func (s NamingScheme) IsValid() bool {
+	switch s {
+	case NamingTimestamp, NamingSequence:
+		return true
+	}
+	return false
+}
+
+// AuditConfig enables the append-only audit log of schema changing commands
+type AuditConfig struct {
+	File  string `json:"file,omitempty"`  // JSONL file, relative to the repository
+	Table bool   `json:"table,omitempty"` // also insert into blueprint_audit of each database
+}
+
+// WebhookConfig is an HTTP endpoint notified when run, rollback or seed
+// starts, succeeds or fails on a database
+type WebhookConfig struct {
+	URL      string            `json:"url"`
+	Events   []WebhookEvent    `json:"events,omitempty"`   // start/success/failure, default all
+	Headers  map[string]string `json:"headers,omitempty"`  // e.g. Authorization
+	Template string            `json:"template,omitempty"` // text/template for the body, default the JSON payload
+	Timeout  uint              `json:"timeout,omitempty"`  // seconds of each attempt, default 10
+	Retries  uint              `json:"retries,omitempty"`  // extra attempts after a failed one
+}
+
+// HookConfig is a shell command or a SQL file run by run, rollback and seed,
+// see HookPoint for when
+type HookConfig struct {
+	Command string `json:"command,omitempty"` // run with sh -c in the repository
+	SQL     string `json:"sql,omitempty"`     // SQL file relative to the repository, run on the database
+}
+
+// Label returns the command or SQL file of the hook for output
+func (h HookConfig) Label() string {
+	if h.SQL != "" {
+		return h.SQL
+	}
+	return h.Command
+}
+
+type HooksConfig struct {
+	BeforeRun       []HookConfig `json:"before_run,omitempty"`
+	AfterRun        []HookConfig `json:"after_run,omitempty"`
+	BeforeMigration []HookConfig `json:"before_migration,omitempty"`
+	AfterMigration  []HookConfig `json:"after_migration,omitempty"`
+}
+
+// At returns the hooks run at point
+func (h *HooksConfig) At(point HookPoint) []HookConfig {
+	if h == nil {
+		return nil
+	}
+	switch point {
+	case HookBeforeRun:
+		return h.BeforeRun
+	case HookAfterRun:
+		return h.AfterRun
+	case HookBeforeMigration:
+		return h.BeforeMigration
+	case HookAfterMigration:
+		return h.AfterMigration
+	}
+	return nil
+}
+
+// SQLFiles returns the SQL files of the hooks relative to workDir, they are not
+// migrations
+func (h *HooksConfig) SQLFiles(workDir string) map[string]struct{} {
+	files := make(map[string]struct{})
+	for _, point := range hookPoints {
+		for _, hook := range h.At(point) {
+			if hook.SQL != "" && !filepath.IsAbs(hook.SQL) {
+				files[filepath.Join(workDir, hook.SQL)] = struct{}{}
+			}
+		}
+	}
+	return files
 }
 
 type Config struct {
-	Env       string     `json:"env"`
-	Databases []DBConfig `json:"databases"`
+	Env        string           `json:"env"`
+	OutOfOrder OutOfOrderPolicy `json:"out_of_order,omitempty"` // allow(default)/warn/deny
+	Naming     NamingScheme     `json:"naming,omitempty"`       // timestamp(default)/sequence
+	Audit      *AuditConfig     `json:"audit,omitempty"`
+	Webhooks   []WebhookConfig  `json:"webhooks,omitempty"`
+	Tracing    *TracingConfig   `json:"tracing,omitempty"`
+	Hooks      *HooksConfig     `json:"hooks,omitempty"`
+	Databases  []DBConfig       `json:"databases"`
 }
 
 var config Config
 
-func loadJsonConfig(filepath string) error {
-	configFile, err := os.ReadFile(path.Join(filepath, BlueprintConfigFileName))
+// configPath returns the config file of the repository: --config, or
+// blueprint.<env>.json for --env if it exists, or blueprint.json
+func configPath(workDir string) string {
+	if configFile != "" {
+		return configFile
+	}
+	if envName != "" {
+		envFile := path.Join(workDir, "blueprint."+envName+".json")
+		if _, err := os.Stat(envFile); err == nil {
+			return envFile
+		}
+	}
+	return path.Join(workDir, BlueprintConfigFileName)
+}
+
+func loadJsonConfig(workDir string) error {
+	content, err := os.ReadFile(configPath(workDir))
 	if err != nil {
 		return err
 	}
 
-	err = json.Unmarshal(configFile, &config)
+	err = json.Unmarshal(content, &config)
 	if err != nil {
 		return err
 	}
 
+	if envName != "" {
+		config.Env = envName
+	}
+
 	// Set default type to mysql for backward compatibility
 	for i := range config.Databases {
 		if config.Databases[i].Type == "" {
@@ -57,5 +199,78 @@ func loadJsonConfig(filepath string) error {
 		}
 	}
 
+	if config.OutOfOrder == "" {
+		config.OutOfOrder = OutOfOrderAllow
+	}
+	if !config.OutOfOrder.IsValid() {
+		return fmt.Errorf("invalid out_of_order policy: %s (allow/warn/deny)", config.OutOfOrder)
+	}
+
+	if config.Naming == "" {
+		config.Naming = NamingTimestamp
+	}
+	if !config.Naming.IsValid() {
+		return fmt.Errorf("invalid naming scheme: %s (timestamp/sequence)", config.Naming)
+	}
+
+	for i, webhook := range config.Webhooks {
+		if webhook.URL == "" {
+			return fmt.Errorf("webhooks[%d] has no url", i)
+		}
+		for _, event := range webhook.Events {
+			if !event.IsValid() {
+				return fmt.Errorf("invalid webhook event: %s (start/success/failure)", event)
+			}
+		}
+		if webhook.Timeout == 0 {
+			config.Webhooks[i].Timeout = 10
+		}
+		if webhook.Template != "" {
+			if _, err := parseWebhookTemplate(webhook.Template); err != nil {
+				return fmt.Errorf("invalid template of webhooks[%d]: %s", i, err)
+			}
+		}
+	}
+
+	if config.Hooks != nil {
+		for _, point := range hookPoints {
+			for i, hook := range config.Hooks.At(point) {
+				if (hook.Command == "") == (hook.SQL == "") {
+					return fmt.Errorf("hooks.%s[%d] needs either command or sql", point, i)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// selectDatabases keeps only the databases given by --db, matched by alias,
+// or by name (file for sqlite) for databases without an alias
+func selectDatabases(aliases []string) error {
+	if len(aliases) == 0 {
+		return nil
+	}
+
+	selected := make([]DBConfig, 0, len(aliases))
+	// a database selected twice, e.g. by alias and again by name, is kept once
+	chosen := make(map[int]bool)
+	for _, alias := range aliases {
+		found := false
+		for idx, db := range config.Databases {
+			if db.Alias == alias || (db.Alias == "" && (db.Name == alias || (db.Type == SQLite && db.File == alias))) {
+				if !chosen[idx] {
+					selected = append(selected, db)
+					chosen[idx] = true
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return usageError("no database matches --db " + alias)
+		}
+	}
+	config.Databases = selected
 	return nil
 }
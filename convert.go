@@ -0,0 +1,486 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// convert 生成的映射文件，import-history 用它把来源工具的版本号对应到 Blueprint 的 migration
+const ConvertMappingFileName string = "blueprint.mapping.json"
+
+type ConvertMapping struct {
+	From       HistorySource         `json:"from"`
+	Migrations []ConvertMappingEntry `json:"migrations"`
+}
+
+type ConvertMappingEntry struct {
+	SourceVersion string `json:"source_version"`
+	SourceFile    string `json:"source_file"`
+	Migration     string `json:"migration"`
+}
+
+// 从其他工具的目录中读出的一个 migration
+type sourceMigration struct {
+	Version     string // 来源工具中的版本号
+	Description string
+	UpFile      string
+	UpSQL       string
+	DownSQL     string
+	HasDown     bool
+}
+
+var (
+	golangMigrateFile = regexp.MustCompile(`^(\d+)_(.*)\.(up|down)\.sql$`)
+	gooseFile         = regexp.MustCompile(`^(\d+)_(.*)\.sql$`)
+	flywayFile        = regexp.MustCompile(`^([VU])([0-9][0-9._]*)__(.*)\.sql$`)
+)
+
+// 把其他工具的 migration 目录转换为 Blueprint 的文件格式
+func convertMigrations(workDir string, source HistorySource, sourceDir string) error {
+	if ok, _ := isBlueprintRepo(workDir); !ok {
+		return withCode(ExitConfig, errors.New("Not a Blueprint repository ("+workDir+")"))
+	}
+	// 仓库下的 .sql 文件都会被当作 migration 读取，源目录留在仓库里时原来的文件也会被执行
+	inside, err := isInsideDir(workDir, sourceDir)
+	if err != nil {
+		return err
+	}
+	if inside {
+		return usageError(fmt.Sprintf("%s is inside the repository, its .sql files would be loaded as migrations too; move it out of %s first", sourceDir, workDir))
+	}
+	mappingFile := filepath.Join(workDir, ConvertMappingFileName)
+	if _, err := os.Stat(mappingFile); err == nil {
+		return fmt.Errorf("%s already exists, remove it to convert again", ConvertMappingFileName)
+	}
+
+	var list []sourceMigration
+	switch source {
+	case SourceGolangMigrate:
+		list, err = readGolangMigrateDir(sourceDir)
+	case SourceGoose:
+		list, err = readGooseDir(sourceDir)
+	case SourceFlyway:
+		list, err = readFlywayDir(sourceDir)
+	default:
+		return usageError("--from must be one of golang-migrate, goose, flyway")
+	}
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return fmt.Errorf("no %s migration found in %s", source, sourceDir)
+	}
+
+	err = loadJsonConfig(workDir)
+	if err != nil {
+		return withCode(ExitConfig, err)
+	}
+	// 按来源工具的顺序重新编号，保证执行顺序完全一致
+	namer, err := newMigrationNamer(workDir, config.Naming)
+	if err != nil {
+		return err
+	}
+	migrations, err := LoadMigrations(workDir, config.Hooks)
+	if err != nil {
+		return err
+	}
+
+	// 先规划好全部要写的文件并检查冲突，再写入临时文件，最后统一改名，
+	// 中途失败时不会留下转换了一半、又没有映射文件的目录
+	mapping := ConvertMapping{From: source}
+	files := make([]convertFile, 0, len(list)*2+1)
+	for _, m := range list {
+		name, rbName := namer.Filename(sanitizeNote(m.Description))
+		migrationName := strings.TrimSuffix(name, ".sql")
+		if migrations.Exists(migrationName) {
+			return fmt.Errorf("migration %s already exists", migrationName)
+		}
+
+		sourceFile, err := filepath.Rel(sourceDir, m.UpFile)
+		if err != nil {
+			sourceFile = m.UpFile
+		}
+		logger.Info(fmt.Sprintf("%s -> %s", filepath.ToSlash(sourceFile), name))
+		if m.HasDown {
+			files = append(files, convertFile{filepath.Join(workDir, name), m.UpSQL})
+			files = append(files, convertFile{filepath.Join(workDir, rbName), m.DownSQL})
+		} else {
+			// 来源工具中没有 down/undo 的 migration 不可回滚
+			files = append(files, convertFile{filepath.Join(workDir, name), "-- +blueprint " + DirectiveIrreversible + "\n" + m.UpSQL})
+		}
+		mapping.Migrations = append(mapping.Migrations, ConvertMappingEntry{
+			SourceVersion: m.Version,
+			SourceFile:    filepath.ToSlash(sourceFile),
+			Migration:     migrationName,
+		})
+	}
+
+	mappingBytes, err := json.MarshalIndent(mapping, "", "    ")
+	if err != nil {
+		return err
+	}
+	files = append(files, convertFile{mappingFile, string(mappingBytes)})
+
+	err = writeConvertFiles(files)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		result.AddFile(file.path)
+	}
+
+	logger.Info(fmt.Sprintf("Converted %d migration(s), mapping written to %s", len(list), ConvertMappingFileName))
+	return nil
+}
+
+// 判断 target 是否就是 dir 或在 dir 之下，符号链接按实际路径比较
+func isInsideDir(dir, target string) (bool, error) {
+	paths := []string{dir, target}
+	for i, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return false, err
+		}
+		if real, err := filepath.EvalSymlinks(abs); err == nil {
+			abs = real
+		}
+		paths[i] = abs
+	}
+	rel, err := filepath.Rel(paths[0], paths[1])
+	if err != nil {
+		return false, nil
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)), nil
+}
+
+type convertFile struct {
+	path    string
+	content string
+}
+
+// 先把全部文件写到临时文件，都成功后再改名为目标文件名，任何一步失败都会删除已写入的文件
+func writeConvertFiles(files []convertFile) (err error) {
+	for _, file := range files {
+		if _, err := os.Stat(file.path); err == nil {
+			return fmt.Errorf("%s already exists", file.path)
+		}
+	}
+
+	written := make([]string, 0, len(files))
+	defer func() {
+		if err != nil {
+			for _, path := range written {
+				os.Remove(path)
+			}
+		}
+	}()
+
+	temps := make([]string, 0, len(files))
+	for _, file := range files {
+		temp := file.path + ".tmp"
+		err = createFile(temp, file.content)
+		if err != nil {
+			return err
+		}
+		written = append(written, temp)
+		temps = append(temps, temp)
+	}
+	for idx, file := range files {
+		// 改名不会检查目标是否存在，再确认一次，避免覆盖期间新建的文件
+		if _, statErr := os.Stat(file.path); statErr == nil {
+			return fmt.Errorf("%s already exists", file.path)
+		}
+		err = os.Rename(temps[idx], file.path)
+		if err != nil {
+			return err
+		}
+		written[idx] = file.path
+	}
+	return nil
+}
+
+// 读取 convert 生成的映射文件，返回来源版本号到 migration 名称的映射
+func loadConvertMapping(workDir string, source HistorySource) (map[string]string, error) {
+	content, err := os.ReadFile(filepath.Join(workDir, ConvertMappingFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mapping := ConvertMapping{}
+	err = json.Unmarshal(content, &mapping)
+	if err != nil {
+		return nil, errorf(ExitConfig, "parse %s failed: %s", ConvertMappingFileName, err)
+	}
+	if mapping.From != source {
+		return nil, nil
+	}
+
+	versions := make(map[string]string)
+	for _, entry := range mapping.Migrations {
+		versions[normalizeVersion(entry.SourceVersion)] = entry.Migration
+	}
+	return versions, nil
+}
+
+// golang-migrate: 000001_create_users.up.sql / 000001_create_users.down.sql
+func readGolangMigrateDir(dir string) ([]sourceMigration, error) {
+	byVersion := make(map[uint64]*sourceMigration)
+	err := walkSQLFiles(dir, func(filePath, filename string) error {
+		matches := golangMigrateFile.FindStringSubmatch(filename)
+		if matches == nil {
+			warnf("%s is not a golang-migrate file, skip", filePath)
+			return nil
+		}
+		version, err := strconv.ParseUint(matches[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+
+		m, exist := byVersion[version]
+		if !exist {
+			m = &sourceMigration{Version: strconv.FormatUint(version, 10), Description: matches[2]}
+			byVersion[version] = m
+		}
+		if matches[3] == "up" {
+			if m.UpFile != "" {
+				return fmt.Errorf("duplicate golang-migrate version %d: %s and %s", version, m.UpFile, filePath)
+			}
+			m.UpFile = filePath
+			m.UpSQL = string(content)
+		} else {
+			m.DownSQL = string(content)
+			m.HasDown = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]uint64, 0, len(byVersion))
+	for version, m := range byVersion {
+		if m.UpFile == "" {
+			return nil, fmt.Errorf("golang-migrate version %d has no up file", version)
+		}
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	list := make([]sourceMigration, 0, len(versions))
+	for _, version := range versions {
+		list = append(list, *byVersion[version])
+	}
+	return list, nil
+}
+
+// goose: 20230101120000_create_users.sql，用 -- +goose Up / -- +goose Down 分隔
+func readGooseDir(dir string) ([]sourceMigration, error) {
+	byVersion := make(map[int64]*sourceMigration)
+	err := filepath.WalkDir(dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		filename := d.Name()
+		if strings.ToLower(filepath.Ext(filename)) == ".go" {
+			warnf("%s is a goose Go migration and cannot be converted, skip", filePath)
+			return nil
+		}
+		if strings.ToLower(filepath.Ext(filename)) != ".sql" {
+			return nil
+		}
+
+		matches := gooseFile.FindStringSubmatch(filename)
+		if matches == nil {
+			warnf("%s is not a goose file, skip", filePath)
+			return nil
+		}
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		if m, exist := byVersion[version]; exist {
+			return fmt.Errorf("duplicate goose version %d: %s and %s", version, m.UpFile, filePath)
+		}
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+
+		up, down, hasDown, statementBlock := splitGooseFile(string(content))
+		if statementBlock {
+			warnf("%s uses StatementBegin/StatementEnd, Blueprint splits statements by ';', please check it", filePath)
+		}
+		byVersion[version] = &sourceMigration{
+			Version:     strconv.FormatInt(version, 10),
+			Description: matches[2],
+			UpFile:      filePath,
+			UpSQL:       up,
+			DownSQL:     down,
+			HasDown:     hasDown,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int64, 0, len(byVersion))
+	for version := range byVersion {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	list := make([]sourceMigration, 0, len(versions))
+	for _, version := range versions {
+		list = append(list, *byVersion[version])
+	}
+	return list, nil
+}
+
+// 拆分 goose 的 Up/Down 部分，去掉其余的 goose 注解
+func splitGooseFile(content string) (up, down string, hasDown, statementBlock bool) {
+	var upLines, downLines []string
+	var section *[]string
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "--") {
+			annotation := strings.TrimSpace(trimmed[2:])
+			if strings.HasPrefix(annotation, "+goose ") {
+				switch strings.ToLower(strings.TrimSpace(annotation[len("+goose "):])) {
+				case "up":
+					section = &upLines
+				case "down":
+					section = &downLines
+					hasDown = true
+				case "statementbegin", "statementend":
+					statementBlock = true
+				}
+				continue
+			}
+		}
+		if section != nil {
+			*section = append(*section, line)
+		}
+	}
+	return strings.TrimSpace(strings.Join(upLines, "\n")) + "\n",
+		strings.TrimSpace(strings.Join(downLines, "\n")) + "\n",
+		hasDown, statementBlock
+}
+
+// Flyway: V1__create_users.sql，回滚对应 U1__create_users.sql（Flyway Teams 的 undo migration）
+func readFlywayDir(dir string) ([]sourceMigration, error) {
+	byVersion := make(map[string]*sourceMigration)
+	undo := make(map[string]string)
+	err := walkSQLFiles(dir, func(filePath, filename string) error {
+		if strings.HasPrefix(filename, "R__") {
+			warnf("%s is a repeatable Flyway migration and cannot be converted, skip", filePath)
+			return nil
+		}
+		matches := flywayFile.FindStringSubmatch(filename)
+		if matches == nil {
+			warnf("%s is not a Flyway file, skip", filePath)
+			return nil
+		}
+		version := strings.ReplaceAll(matches[2], "_", ".")
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+
+		if matches[1] == "U" {
+			undo[version] = string(content)
+			return nil
+		}
+		if m, exist := byVersion[version]; exist {
+			return fmt.Errorf("duplicate Flyway version %s: %s and %s", version, m.UpFile, filePath)
+		}
+		byVersion[version] = &sourceMigration{
+			Version:     version,
+			Description: matches[3],
+			UpFile:      filePath,
+			UpSQL:       string(content),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]string, 0, len(byVersion))
+	for version, m := range byVersion {
+		if down, exist := undo[version]; exist {
+			m.DownSQL = down
+			m.HasDown = true
+		}
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return compareFlywayVersion(versions[i], versions[j]) < 0 })
+
+	list := make([]sourceMigration, 0, len(versions))
+	for _, version := range versions {
+		list = append(list, *byVersion[version])
+	}
+	return list, nil
+}
+
+// 按 Flyway 的规则比较版本号：逐段按数字比较，1.10 > 1.9
+func compareFlywayVersion(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y uint64
+		if i < len(as) {
+			x, _ = strconv.ParseUint(as[i], 10, 64)
+		}
+		if i < len(bs) {
+			y, _ = strconv.ParseUint(bs[i], 10, 64)
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func walkSQLFiles(dir string, fn func(filePath, filename string) error) error {
+	return filepath.WalkDir(dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.ToLower(filepath.Ext(d.Name())) != ".sql" {
+			return nil
+		}
+		return fn(filePath, d.Name())
+	})
+}
+
+// 文件名中只保留字母、数字和下划线
+func sanitizeNote(note string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(note) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return strings.Trim(b.String(), "_")
+}
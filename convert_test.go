@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsInsideDir(t *testing.T) {
+	repo := t.TempDir()
+	outside := t.TempDir()
+	writeTestFiles(t, repo, map[string]string{"old/000001_a.up.sql": ""})
+	link := filepath.Join(outside, "link")
+	if err := os.Symlink(filepath.Join(repo, "old"), link); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		want   bool
+	}{
+		{"the repository itself", repo, true},
+		{"sub directory", filepath.Join(repo, "old"), true},
+		{"relative path into the repository", filepath.Join(repo, "old", "..", "old"), true},
+		{"symlink into the repository", link, true},
+		{"sibling directory", outside, false},
+		{"name starting with dots", filepath.Join(repo, "..repo"), true},
+		{"parent directory", filepath.Dir(repo), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := isInsideDir(repo, tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("isInsideDir(%q) = %v, want %v", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertRefusesSourceInsideRepository(t *testing.T) {
+	setupCommandTest(t, "convert")
+	repo := t.TempDir()
+	writeTestFiles(t, repo, map[string]string{
+		BlueprintConfigFileName: `{"env": "dev", "naming": "sequence", "databases": []}`,
+		"old/000001_a.up.sql":   "CREATE TABLE a (id INT);",
+		"old/000001_a.down.sql": "DROP TABLE a;",
+	})
+
+	err := convertMigrations(repo, SourceGolangMigrate, filepath.Join(repo, "old"))
+	if exitCode(err) != ExitUsage {
+		t.Fatalf("err = %v (exit code %d), want a usage error", err, exitCode(err))
+	}
+	if _, err := os.Stat(filepath.Join(repo, ConvertMappingFileName)); !os.IsNotExist(err) {
+		t.Errorf("mapping file was written: %v", err)
+	}
+}
+
+// 只比较版本号、描述和 up/down 内容，文件路径取决于临时目录
+func summarizeSourceMigrations(list []sourceMigration) []string {
+	got := make([]string, 0, len(list))
+	for _, m := range list {
+		summary := fmt.Sprintf("%s %s up=%q", m.Version, m.Description, m.UpSQL)
+		if m.HasDown {
+			summary += fmt.Sprintf(" down=%q", m.DownSQL)
+		}
+		got = append(got, summary)
+	}
+	return got
+}
+
+func TestReadSourceDirs(t *testing.T) {
+	tests := []struct {
+		name    string
+		read    func(dir string) ([]sourceMigration, error)
+		files   map[string]string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "golang-migrate", read: readGolangMigrateDir,
+			files: map[string]string{
+				"000010_add_index.up.sql":      "CREATE INDEX idx ON users (id);",
+				"000002_create_users.up.sql":   "CREATE TABLE users (id INT);",
+				"000002_create_users.down.sql": "DROP TABLE users;",
+				"nested/000003_posts.up.sql":   "CREATE TABLE posts (id INT);",
+				"README.md":                    "not a migration",
+				"seed.sql":                     "INSERT INTO users VALUES (1);",
+			},
+			want: []string{
+				`2 create_users up="CREATE TABLE users (id INT);" down="DROP TABLE users;"`,
+				`3 posts up="CREATE TABLE posts (id INT);"`,
+				`10 add_index up="CREATE INDEX idx ON users (id);"`,
+			},
+		},
+		{
+			name: "golang-migrate down without up", read: readGolangMigrateDir,
+			files:   map[string]string{"000001_a.down.sql": "DROP TABLE a;"},
+			wantErr: "version 1 has no up file",
+		},
+		{
+			name: "golang-migrate duplicate version", read: readGolangMigrateDir,
+			files:   map[string]string{"1_a.up.sql": "", "01_b.up.sql": ""},
+			wantErr: "duplicate golang-migrate version 1",
+		},
+		{
+			name: "goose", read: readGooseDir,
+			files: map[string]string{
+				"20230102000000_add_index.sql":    "-- +goose Up\nCREATE INDEX idx ON users (id);\n",
+				"20230101000000_create_users.sql": "-- +goose Up\nCREATE TABLE users (id INT);\n\n-- +goose Down\nDROP TABLE users;\n",
+				"20230103000000_seed.go":          "package migrations",
+			},
+			want: []string{
+				`20230101000000 create_users up="CREATE TABLE users (id INT);\n" down="DROP TABLE users;\n"`,
+				`20230102000000 add_index up="CREATE INDEX idx ON users (id);\n"`,
+			},
+		},
+		{
+			name: "goose duplicate version", read: readGooseDir,
+			files:   map[string]string{"1_a.sql": "", "001_b.sql": ""},
+			wantErr: "duplicate goose version 1",
+		},
+		{
+			name: "flyway orders versions by number and pairs undo files", read: readFlywayDir,
+			files: map[string]string{
+				"V1__init.sql":               "CREATE TABLE a (id INT);",
+				"V1.10__tenth.sql":           "CREATE TABLE j (id INT);",
+				"V1_9__ninth.sql":            "CREATE TABLE i (id INT);",
+				"U1.10__tenth.sql":           "DROP TABLE j;",
+				"R__views.sql":               "CREATE VIEW v AS SELECT 1;",
+				"callbacks/afterMigrate.sql": "SELECT 1;",
+			},
+			want: []string{
+				`1 init up="CREATE TABLE a (id INT);"`,
+				`1.9 ninth up="CREATE TABLE i (id INT);"`,
+				`1.10 tenth up="CREATE TABLE j (id INT);" down="DROP TABLE j;"`,
+			},
+		},
+		{
+			name: "flyway duplicate version", read: readFlywayDir,
+			files:   map[string]string{"V1_1__a.sql": "", "V1.1__b.sql": ""},
+			wantErr: "duplicate Flyway version 1.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupCommandTest(t, "convert")
+			dir := t.TempDir()
+			writeTestFiles(t, dir, tt.files)
+
+			list, err := tt.read(dir)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := summarizeSourceMigrations(list); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("migrations =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestSplitGooseFile(t *testing.T) {
+	tests := []struct {
+		name           string
+		content        string
+		up, down       string
+		hasDown        bool
+		statementBlock bool
+	}{
+		{
+			name:    "up and down",
+			content: "-- +goose Up\nCREATE TABLE a (id INT);\n-- +goose Down\nDROP TABLE a;\n",
+			up:      "CREATE TABLE a (id INT);\n", down: "DROP TABLE a;\n", hasDown: true,
+		},
+		{
+			name:    "lines before the first annotation are dropped",
+			content: "-- comment\n-- +goose Up\nSELECT 1;\n",
+			up:      "SELECT 1;\n", down: "\n",
+		},
+		{
+			name:    "statement blocks and annotation case",
+			content: "--  +goose UP\n-- +goose StatementBegin\nCREATE FUNCTION f() ...;\n-- +goose StatementEnd\n-- +goose down\nDROP FUNCTION f;",
+			up:      "CREATE FUNCTION f() ...;\n", down: "DROP FUNCTION f;\n", hasDown: true, statementBlock: true,
+		},
+		{
+			name:    "ordinary comments are kept",
+			content: "-- +goose Up\n-- create the table\nCREATE TABLE a (id INT);",
+			up:      "-- create the table\nCREATE TABLE a (id INT);\n", down: "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up, down, hasDown, statementBlock := splitGooseFile(tt.content)
+			if up != tt.up || down != tt.down || hasDown != tt.hasDown || statementBlock != tt.statementBlock {
+				t.Errorf("splitGooseFile() = %q, %q, %v, %v, want %q, %q, %v, %v",
+					up, down, hasDown, statementBlock, tt.up, tt.down, tt.hasDown, tt.statementBlock)
+			}
+		})
+	}
+}
+
+func TestCompareFlywayVersion(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1", "1", 0},
+		{"1.0", "1", 0},
+		{"1.9", "1.10", -1},
+		{"2", "1.10", 1},
+		{"1.01", "1.1", 0},
+		{"1.1.1", "1.1", 1},
+	}
+	for _, tt := range tests {
+		if got := compareFlywayVersion(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareFlywayVersion(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeNote(t *testing.T) {
+	tests := map[string]string{
+		"create_users":       "create_users",
+		"Create Users Table": "create_users_table",
+		"add-index.v2":       "add_index_v2",
+		"__trim__":           "trim",
+		"用户表":                "",
+	}
+	for note, want := range tests {
+		if got := sanitizeNote(note); got != want {
+			t.Errorf("sanitizeNote(%q) = %q, want %q", note, got, want)
+		}
+	}
+}
+
+func TestConvertFlywayMigrations(t *testing.T) {
+	setupCommandTest(t, "convert")
+	repo := t.TempDir()
+	source := t.TempDir()
+	writeTestFiles(t, repo, map[string]string{
+		BlueprintConfigFileName: `{"env": "dev", "naming": "sequence", "databases": []}`,
+	})
+	writeTestFiles(t, source, map[string]string{
+		"V1.10__Add Index.sql":   "CREATE INDEX idx ON users (id);",
+		"V1.9__create_users.sql": "CREATE TABLE users (id INT);",
+		"U1.9__create_users.sql": "DROP TABLE users;",
+	})
+
+	err := convertMigrations(repo, SourceFlyway, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrations, err := LoadMigrations(repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, 0)
+	for _, name := range migrations.GetNames() {
+		got = append(got, fmt.Sprintf("%s irreversible=%v", name, migrations.GetInfo(name).IsIrreversible()))
+	}
+	want := []string{"000001_create_users irreversible=false", "000002_add_index irreversible=true"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("migrations = %q, want %q", got, want)
+	}
+
+	// import-history 通过映射文件把 Flyway 的版本号对应到转换后的 migration
+	versions, err := loadConvertMapping(repo, SourceFlyway)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantVersions := map[string]string{"1.9": "000001_create_users", "1.10": "000002_add_index"}
+	if !reflect.DeepEqual(versions, wantVersions) {
+		t.Errorf("mapping = %v, want %v", versions, wantVersions)
+	}
+	if versions, _ := loadConvertMapping(repo, SourceGoose); versions != nil {
+		t.Errorf("mapping of another source = %v, want nil", versions)
+	}
+
+	// 映射文件已存在时拒绝再次转换
+	err = convertMigrations(repo, SourceFlyway, source)
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("second convert err = %v, want the mapping file to block it", err)
+	}
+}
@@ -2,6 +2,11 @@ package main
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type DatabaseDriver interface {
@@ -10,12 +15,83 @@ type DatabaseDriver interface {
 	GetMigrationInfos(db *sql.DB) ([]MigrationRec, error)
 	InsertMigrationInfo(db *sql.Tx, info MigrationRec) error
 	DeleteMigrationInfo(db *sql.Tx, id uint) error
-	ExecMigration(db *sql.Tx, migrationSQL string) error
+	SplitStatements(migrationSQL string) []string
 	ShowTableCreate(db *sql.DB, table string) (string, error)
 	GetTables(db *sql.DB) ([]string, error)
+	CheckAuditTable(db *sql.DB) error
+	InsertAuditRecord(db *sql.DB, record AuditRecord, content string) error
+	Placeholder(n int) string // 第 n 个（从 1 开始）参数的占位符
+	QuoteIdentifier(name string) string
+	CheckSeedTable(db *sql.DB) error
+	GetSeedRecords(db *sql.DB) ([]SeedRec, error)
+	SaveSeedRecord(db *sql.Tx, rec SeedRec) error
+}
+
+// 审计表名，dump 时会被忽略
+const AuditTableName = "blueprint_audit"
+
+// seed 执行记录表名，dump 时会被忽略
+const SeedTableName = "blueprint_seeds"
+
+// 数据库 seed 记录表结构，每个 seed 只保留最近一次执行的记录
+type SeedRec struct {
+	Seed     string
+	Checksum string
+	Env      string
+	SeededAt string
+}
+
+// 驱动的错误信息中可能带有完整的 DSN（如 URL 解析失败时），换成隐去密码的 safeDSN。
+// 密码再短也会被隐去，不依赖 logger 按内容替换
+func redactDSN(err error, dsn, safeDSN string) error {
+	msg := err.Error()
+	quoted := strings.Trim(strconv.Quote(dsn), `"`)
+	if !strings.Contains(msg, dsn) && !strings.Contains(msg, quoted) {
+		return err
+	}
+	msg = strings.ReplaceAll(msg, dsn, safeDSN)
+	msg = strings.ReplaceAll(msg, quoted, safeDSN)
+	return errors.New(msg)
 }
 
 type DBConnection struct {
 	*sql.DB
 	Driver DatabaseDriver
+	Config DBConfig
+}
+
+// 逐条执行 migration 中的语句，并记录每条语句的耗时和影响行数
+func (c *DBConnection) ExecMigration(tx *sql.Tx, migrationSQL string, span *Span) ([]*StatementResult, error) {
+	statements := c.Driver.SplitStatements(migrationSQL)
+	results := make([]*StatementResult, 0, len(statements))
+	for idx, statement := range statements {
+		// 执行前先输出，语句卡住时可以知道是哪一条
+		logger.Info(fmt.Sprintf("    [%d/%d] %s", idx+1, len(statements), statementPreview(statement)))
+		logger.Debug("exec statement", "db", c.Config.Label(), "sql", statement)
+
+		stmtResult := &StatementResult{SQL: statement}
+		results = append(results, stmtResult)
+
+		stmtSpan := tracer.StartSpan(span, statementOperation(statement), spanKindClient,
+			"db.system", dbSystem(c.Config.Type), "db.statement", statement)
+		start := time.Now()
+		res, err := tx.Exec(statement)
+		stmtResult.Duration = time.Since(start)
+		stmtResult.DurationMs = durationMs(stmtResult.Duration)
+		if err != nil {
+			stmtSpan.End(err)
+			stmtResult.Error = err.Error()
+			logger.Info(fmt.Sprintf("    [%d/%d] failed after %s", idx+1, len(statements), formatDuration(stmtResult.Duration)))
+			return results, err
+		}
+
+		// 部分驱动不支持 RowsAffected，此时记为 0
+		stmtResult.RowsAffected, _ = res.RowsAffected()
+		stmtSpan.SetAttr("blueprint.rows_affected", stmtResult.RowsAffected)
+		stmtSpan.End(nil)
+		logger.Info(fmt.Sprintf("    [%d/%d] done in %s, %d row(s) affected",
+			idx+1, len(statements), formatDuration(stmtResult.Duration), stmtResult.RowsAffected))
+	}
+
+	return results, nil
 }
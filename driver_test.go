@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{"single statement", "CREATE TABLE users (id INT);", []string{"CREATE TABLE users (id INT)"}},
+		{"without trailing semicolon", "DROP TABLE a; DROP TABLE b", []string{"DROP TABLE a", "DROP TABLE b"}},
+		{"empty statements", ";;\n;", []string{}},
+		{
+			"directive after the last statement",
+			"CREATE TABLE users (id INT);\n-- +blueprint irreversible\n",
+			[]string{"CREATE TABLE users (id INT)"},
+		},
+		{
+			"comment lines between statements",
+			"DROP TABLE a;\n-- +blueprint depends-on: 000001_a\n-- +blueprint env: dev\n;\nDROP TABLE b;",
+			[]string{"DROP TABLE a", "DROP TABLE b"},
+		},
+		{
+			"comment before a statement is kept",
+			"-- users\nCREATE TABLE users (id INT);",
+			[]string{"-- users\nCREATE TABLE users (id INT)"},
+		},
+	}
+	drivers := []DatabaseDriver{MySQLDriver{}, PostgreSQLDriver{}, SQLiteDriver{}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, driver := range drivers {
+				if got := driver.SplitStatements(tt.sql); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%T.SplitStatements(%q) = %q, want %q", driver, tt.sql, got, tt.want)
+				}
+			}
+		})
+	}
+}
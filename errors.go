@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 进程退出码，脚本可以据此区分失败的原因
+type ExitCode int
+
+const (
+	ExitOK          ExitCode = 0
+	ExitError       ExitCode = 1 // 其他错误
+	ExitUsage       ExitCode = 2 // 命令或参数错误
+	ExitConfig      ExitCode = 3 // blueprint.json 或 migration 文件有误，或不是 Blueprint 仓库
+	ExitDatabase    ExitCode = 4 // 无法连接或查询数据库
+	ExitMigration   ExitCode = 5 // migration 执行到一半失败，事务已回滚
+	ExitDrift       ExitCode = 6 // 数据库中的记录和 migration 文件对不上
+	ExitNothingToDo ExitCode = 7 // 没有可以执行的内容，如没有可回滚的 migration
+	ExitRefused     ExitCode = 8 // 拒绝执行，如遇到不可回滚的 migration 或确认时选择了否
+)
+
+// 带退出码的错误
+type CommandError struct {
+	Code ExitCode
+	Err  error
+}
+
+func (e *CommandError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// 给错误加上退出码，已经带有退出码的错误保持不变
+func withCode(code ExitCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) {
+		return err
+	}
+	return &CommandError{Code: code, Err: err}
+}
+
+// 和 fmt.Errorf 一样，用 %w 包装的错误如果带有退出码则以其为准
+func errorf(code ExitCode, format string, a ...any) error {
+	return withCode(code, fmt.Errorf(format, a...))
+}
+
+func usageError(msg string) error {
+	return &CommandError{Code: ExitUsage, Err: errors.New(msg)}
+}
+
+// 错误对应的退出码
+func exitCode(err error) ExitCode {
+	if err == nil {
+		return ExitOK
+	}
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Code
+	}
+	return ExitError
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type GraphFormat string
+
+const (
+	GraphDOT     GraphFormat = "dot"     // Graphviz
+	GraphMermaid GraphFormat = "mermaid" // 可以直接贴到 Markdown 中
+)
+
+func (f GraphFormat) IsValid() bool {
+	switch f {
+	case GraphDOT, GraphMermaid:
+		return true
+	}
+	return false
+}
+
+// 输出 migration 之间的依赖关系图，箭头从被依赖的 migration 指向依赖它的 migration，即执行顺序。
+// 默认只包含声明了依赖或被依赖的 migration，all 为 true 时包含全部 migration；
+// 指定 focus 时只包含该 migration 以及直接或间接依赖它、被它依赖的 migration
+func showGraph(workDir string, format GraphFormat, all bool, focus string) error {
+	if outputFormat == OutputJSON {
+		return usageError("graph does not support --output json")
+	}
+	if ok, _ := isBlueprintRepo(workDir); !ok {
+		return withCode(ExitConfig, errors.New("Not a Blueprint repository ("+workDir+")"))
+	}
+	err := loadJsonConfig(workDir)
+	if err != nil {
+		return withCode(ExitConfig, err)
+	}
+	migrations, err := LoadMigrations(workDir, config.Hooks)
+	if err != nil {
+		return err
+	}
+
+	involved := make(map[string]bool)
+	if focus != "" {
+		focus = strings.TrimSuffix(focus, ".sql")
+		if !migrations.Exists(focus) {
+			return usageError("no migration named " + focus)
+		}
+		involved = relatedMigrations(migrations, focus)
+	} else {
+		for _, name := range migrations.GetNames() {
+			dependencies := migrations.GetInfo(name).DependsOn
+			if all || len(dependencies) > 0 {
+				involved[name] = true
+			}
+			for _, dependency := range dependencies {
+				involved[dependency] = true
+			}
+		}
+	}
+	nodes := make([]string, 0, len(involved))
+	for _, name := range migrations.GetNames() {
+		if involved[name] {
+			nodes = append(nodes, name)
+		}
+	}
+
+	switch format {
+	case GraphMermaid:
+		fmt.Print(renderMermaidGraph(migrations, nodes))
+	default:
+		fmt.Print(renderDOTGraph(migrations, nodes))
+	}
+	return nil
+}
+
+// 返回 name 以及它直接或间接依赖的、直接或间接依赖它的 migration
+func relatedMigrations(migrations *Migrations, name string) map[string]bool {
+	dependents := make(map[string][]string)
+	for _, other := range migrations.GetNames() {
+		for _, dependency := range migrations.GetInfo(other).DependsOn {
+			dependents[dependency] = append(dependents[dependency], other)
+		}
+	}
+
+	related := map[string]bool{name: true}
+	walk := func(next func(string) []string) {
+		queue := []string{name}
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			for _, other := range next(current) {
+				if !related[other] {
+					related[other] = true
+					queue = append(queue, other)
+				}
+			}
+		}
+	}
+	walk(func(current string) []string { return migrations.GetInfo(current).DependsOn })
+	walk(func(current string) []string { return dependents[current] })
+	return related
+}
+
+func renderDOTGraph(migrations *Migrations, nodes []string) string {
+	quote := func(name string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+	}
+
+	var buf strings.Builder
+	buf.WriteString("digraph migrations {\n")
+	buf.WriteString("    rankdir=LR;\n")
+	buf.WriteString("    node [shape=box];\n")
+	for _, name := range nodes {
+		fmt.Fprintf(&buf, "    %s;\n", quote(name))
+	}
+	for _, name := range nodes {
+		for _, dependency := range migrations.GetInfo(name).DependsOn {
+			fmt.Fprintf(&buf, "    %s -> %s;\n", quote(dependency), quote(name))
+		}
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+// Mermaid 的节点 id 不能包含任意字符，用序号作 id，名称作标签
+func renderMermaidGraph(migrations *Migrations, nodes []string) string {
+	ids := make(map[string]string, len(nodes))
+	var buf strings.Builder
+	buf.WriteString("flowchart LR\n")
+	for idx, name := range nodes {
+		ids[name] = fmt.Sprintf("m%d", idx+1)
+		fmt.Fprintf(&buf, "    %s[\"%s\"]\n", ids[name], strings.ReplaceAll(name, `"`, "#quot;"))
+	}
+	for _, name := range nodes {
+		for _, dependency := range migrations.GetInfo(name).DependsOn {
+			fmt.Fprintf(&buf, "    %s --> %s\n", ids[dependency], ids[name])
+		}
+	}
+	return buf.String()
+}
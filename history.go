@@ -0,0 +1,448 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 可以导入执行记录的其他 migration 工具
+type HistorySource string
+
+const (
+	SourceLaravel       HistorySource = "laravel"
+	SourceGolangMigrate HistorySource = "golang-migrate"
+	SourceGoose         HistorySource = "goose"
+	SourceFlyway        HistorySource = "flyway"
+)
+
+func (s HistorySource) IsValid() bool {
+	switch s {
+	case SourceLaravel, SourceGolangMigrate, SourceGoose, SourceFlyway:
+		return true
+	}
+	return false
+}
+
+// 各工具默认的记录表
+func (s HistorySource) DefaultTable() string {
+	switch s {
+	case SourceLaravel:
+		return "migrations"
+	case SourceGolangMigrate:
+		return "schema_migrations"
+	case SourceGoose:
+		return "goose_db_version"
+	case SourceFlyway:
+		return "flyway_schema_history"
+	}
+	return ""
+}
+
+// 从其他工具的记录表中读出的一条已执行 migration
+type historyEntry struct {
+	Version string // 来源工具中的版本号，laravel 为空
+	Name    string // 来源工具中的名称，用于提示
+	Batch   uint
+}
+
+func (e historyEntry) String() string {
+	return strings.TrimSpace(e.Version + " " + e.Name)
+}
+
+// 把其他工具的执行记录导入到 Blueprint 的 migrations 表
+func importHistory(migrationPath string, dbs []*DBConnection, source HistorySource, table string, dryRun bool) error {
+	migrations, err := LoadMigrations(migrationPath, config.Hooks)
+	if err != nil {
+		return err
+	}
+	if table == "" {
+		table = source.DefaultTable()
+	}
+	result.DryRun = dryRun
+
+	// 优先使用 convert 生成的映射，否则按文件名中的版本号匹配
+	versions, err := loadConvertMapping(migrationPath, source)
+	if err != nil {
+		return err
+	}
+	// Laravel 按名称匹配，不需要版本号
+	if versions == nil && source != SourceLaravel {
+		versions, err = localVersions(migrations)
+		if err != nil {
+			return withCode(ExitConfig, err)
+		}
+	}
+
+	for _, db := range dbs {
+		dbResult := result.AddDatabase(db)
+
+		// Laravel 的记录表和 Blueprint 的结构一样，直接沿用即可
+		if source == SourceLaravel && table == "migrations" {
+			err := adoptLaravelTable(db, dbResult, migrations, dryRun)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		entries, err := readHistory(db, source, quoteTableName(db.Driver, table), versions)
+		if err != nil {
+			return errorf(ExitDatabase, "read %s history from %s of db[%s] failed: %w", source, table, db.Config.Label(), err)
+		}
+
+		// 找到对应的 Blueprint migration，有任何一条对不上就不导入
+		names := make([]string, 0, len(entries))
+		unmatched := make([]string, 0)
+		for _, entry := range entries {
+			name := ""
+			if entry.Version == "" {
+				if migrations.Exists(entry.Name) {
+					name = entry.Name
+				}
+			} else {
+				name = versions[normalizeVersion(entry.Version)]
+			}
+			if name == "" {
+				unmatched = append(unmatched, "  "+entry.String())
+				continue
+			}
+			names = append(names, name)
+		}
+		if len(unmatched) > 0 {
+			return errorf(ExitDrift, "db[%s] has %s history that matches no migration file:\n%s",
+				db.Config.Label(), source, strings.Join(unmatched, "\n"))
+		}
+
+		// dry run 时 migrations 表可能还不存在，读不到就当作没有记录
+		recs, err := appliedRecords(db, dryRun)
+		if err != nil && !dryRun {
+			return err
+		}
+		maxBatch := uint(0)
+		recMap := make(map[string]struct{})
+		for _, rec := range recs {
+			if rec.Batch > maxBatch {
+				maxBatch = rec.Batch
+			}
+			recMap[rec.Migration] = struct{}{}
+		}
+
+		logger.Info(fmt.Sprintf("Database[%s]", db.Config.Label()))
+		// dry run 时 tx 为 nil，只列出会导入的记录
+		record := func(tx *sql.Tx) error {
+			for idx, name := range names {
+				if _, exist := recMap[name]; exist {
+					logger.Info(fmt.Sprintf("  %s already recorded, skip", name))
+					dbResult.AddMigration(name, 0, StatusSkipped)
+					continue
+				}
+				batch := maxBatch + entries[idx].Batch
+				logger.Info(fmt.Sprintf("  Batch[%d] %s <- %s", batch, name, entries[idx].String()))
+				if dryRun {
+					dbResult.AddMigration(name, batch, StatusPending)
+					continue
+				}
+				migrationResult := dbResult.AddMigration(name, batch, StatusImported)
+
+				// 保存回滚 SQL，导入后就可以直接 rollback
+				migration := migrations.GetInfo(name)
+				err := migration.LoadSQLFile()
+				if err != nil {
+					return err
+				}
+				migrationResult.Checksum = sqlChecksum(migration.GetUpSQL())
+				err = db.Driver.InsertMigrationInfo(tx, MigrationRec{
+					Migration: name,
+					Batch:     batch,
+					DownSQL:   sql.NullString{String: migration.GetDownSQL(), Valid: true},
+				})
+				if err != nil {
+					return err
+				}
+				recMap[name] = struct{}{}
+			}
+			return nil
+		}
+		if dryRun {
+			err = record(nil)
+		} else {
+			err = DoTransaction(db.DB, record)
+		}
+		if err != nil {
+			return withCode(ExitMigration, err)
+		}
+	}
+
+	if dryRun {
+		logger.Info("Dry run, nothing was written")
+	}
+	return nil
+}
+
+// Laravel 的 migrations 表就是 Blueprint 的记录表，只需检查记录和文件是否对得上
+func adoptLaravelTable(db *DBConnection, dbResult *DatabaseResult, migrations *Migrations, dryRun bool) error {
+	// Blueprint 需要在 Laravel 的表上加一个 down_sql 字段，改动别的工具的表要说明
+	if missingDownSQLColumn(db.DB) {
+		if dryRun {
+			warnf("the Laravel migrations table of db[%s] has no down_sql column, import-history will add it (Laravel ignores it)", db.Config.Label())
+		} else {
+			warnf("adding a nullable down_sql column to the Laravel migrations table of db[%s] (Laravel ignores it)", db.Config.Label())
+		}
+	}
+	recs, err := appliedRecords(db, dryRun)
+	if err != nil {
+		return err
+	}
+
+	missing := make([]string, 0)
+	for _, rec := range recs {
+		if !migrations.Exists(rec.Migration) {
+			missing = append(missing, fmt.Sprintf("  Batch[%d] %s", rec.Batch, rec.Migration))
+			dbResult.AddMigration(rec.Migration, rec.Batch, StatusApplied, "file_missing")
+			continue
+		}
+		dbResult.AddMigration(rec.Migration, rec.Batch, StatusApplied)
+	}
+	logger.Info(fmt.Sprintf("Database[%s] already uses the Laravel migrations table, %d record(s) adopted", db.Config.Label(), len(recs)))
+	if len(missing) > 0 {
+		warnf("these records match no migration file:\n%s", strings.Join(missing, "\n"))
+	}
+	return nil
+}
+
+// 读取 migrations 表中的记录。dry run 时不修改表结构，只读取不依赖 down_sql 字段的列
+func appliedRecords(db *DBConnection, dryRun bool) ([]MigrationRec, error) {
+	if dryRun {
+		entries, err := readLaravelHistory(db.DB, "migrations")
+		if err != nil {
+			return nil, errorf(ExitDatabase, "get migration infos error: %s", err.Error())
+		}
+		recs := make([]MigrationRec, 0, len(entries))
+		for _, entry := range entries {
+			recs = append(recs, MigrationRec{Migration: entry.Name, Batch: entry.Batch})
+		}
+		return recs, nil
+	}
+
+	err := db.Driver.CheckMigrationInfoTable(db.DB)
+	if err != nil {
+		return nil, errorf(ExitDatabase, "check migration info failed: %s", err.Error())
+	}
+	recs, err := db.Driver.GetMigrationInfos(db.DB)
+	if err != nil {
+		return nil, errorf(ExitDatabase, "get migration infos error: %s", err.Error())
+	}
+	return recs, nil
+}
+
+// migrations 表存在但没有 down_sql 字段
+func missingDownSQLColumn(db *sql.DB) bool {
+	probe := func(column string) bool {
+		rows, err := db.Query("SELECT " + column + " FROM migrations WHERE 1 = 0")
+		if err != nil {
+			return false
+		}
+		rows.Close()
+		return true
+	}
+	return probe("migration") && !probe("down_sql")
+}
+
+// 读取其他工具的记录表，返回已执行的 migration
+func readHistory(db *DBConnection, source HistorySource, table string, versions map[string]string) ([]historyEntry, error) {
+	switch source {
+	case SourceLaravel:
+		return readLaravelHistory(db.DB, table)
+	case SourceGolangMigrate:
+		return readGolangMigrateHistory(db.DB, table, versions)
+	case SourceGoose:
+		return readGooseHistory(db.DB, table)
+	case SourceFlyway:
+		return readFlywayHistory(db.DB, table)
+	}
+	return nil, errors.New("unsupported source: " + string(source))
+}
+
+// 表名为自定义名称的 Laravel 记录表，批次号保持不变
+func readLaravelHistory(db *sql.DB, table string) ([]historyEntry, error) {
+	rows, err := db.Query("SELECT migration, batch FROM " + table + " ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]historyEntry, 0)
+	for rows.Next() {
+		entry := historyEntry{}
+		err = rows.Scan(&entry.Name, &entry.Batch)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// golang-migrate 只记录当前版本，不大于该版本的 migration 都视为已执行
+func readGolangMigrateHistory(db *sql.DB, table string, versions map[string]string) ([]historyEntry, error) {
+	var current int64
+	var dirty bool
+	err := db.QueryRow("SELECT version, dirty FROM "+table).Scan(&current, &dirty)
+	if err == sql.ErrNoRows {
+		return []historyEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if dirty {
+		return nil, errorf(ExitDrift, "version %d is dirty, fix it with golang-migrate first", current)
+	}
+
+	applied := make([]uint64, 0)
+	for version := range versions {
+		v, err := strconv.ParseUint(version, 10, 64)
+		if err != nil {
+			continue
+		}
+		if current >= 0 && v <= uint64(current) {
+			applied = append(applied, v)
+		}
+	}
+	sort.Slice(applied, func(i, j int) bool { return applied[i] < applied[j] })
+
+	entries := make([]historyEntry, 0, len(applied))
+	for _, v := range applied {
+		entries = append(entries, historyEntry{Version: strconv.FormatUint(v, 10), Batch: 1})
+	}
+	return entries, nil
+}
+
+// goose 每次 up/down 都会插入一行，以每个版本最新的一行为准
+func readGooseHistory(db *sql.DB, table string) ([]historyEntry, error) {
+	rows, err := db.Query("SELECT version_id, is_applied FROM " + table + " ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	order := make([]int64, 0)
+	applied := make(map[int64]bool)
+	for rows.Next() {
+		var version int64
+		var isApplied bool
+		err = rows.Scan(&version, &isApplied)
+		if err != nil {
+			return nil, err
+		}
+		// version 0 是 goose 初始化时插入的
+		if version == 0 {
+			continue
+		}
+		if _, exist := applied[version]; !exist {
+			order = append(order, version)
+		}
+		applied[version] = isApplied
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	entries := make([]historyEntry, 0)
+	for _, version := range order {
+		if applied[version] {
+			entries = append(entries, historyEntry{Version: strconv.FormatInt(version, 10), Batch: 1})
+		}
+	}
+	return entries, nil
+}
+
+// Flyway 只导入执行成功的版本化 migration，忽略 baseline 和可重复执行的 migration
+func readFlywayHistory(db *sql.DB, table string) ([]historyEntry, error) {
+	rows, err := db.Query("SELECT version, description, type, success FROM " + table + " ORDER BY installed_rank")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	order := make([]string, 0)
+	descriptions := make(map[string]string)
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var version sql.NullString
+		var description, migrationType string
+		var success bool
+		err = rows.Scan(&version, &description, &migrationType, &success)
+		if err != nil {
+			return nil, err
+		}
+		if !version.Valid || !success || strings.EqualFold(migrationType, "BASELINE") {
+			continue
+		}
+		if _, exist := applied[version.String]; !exist {
+			order = append(order, version.String)
+		}
+		descriptions[version.String] = description
+		// UNDO_SQL 等类型表示该版本已被撤销
+		applied[version.String] = !strings.HasPrefix(strings.ToUpper(migrationType), "UNDO")
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	entries := make([]historyEntry, 0)
+	for _, version := range order {
+		if applied[version] {
+			entries = append(entries, historyEntry{Version: version, Name: descriptions[version], Batch: 1})
+		}
+	}
+	return entries, nil
+}
+
+// 本地 migration 的版本号（去掉前导 0）到名称的映射，
+// 有多个 migration 的版本号相同时无法按版本号匹配，返回错误
+func localVersions(migrations *Migrations) (map[string]string, error) {
+	versions := make(map[string]string)
+	collisions := make([]string, 0)
+	for _, name := range migrations.GetNames() {
+		prefix := versionPrefix(name)
+		if prefix == "" {
+			continue
+		}
+		version := normalizeVersion(prefix)
+		if other, exist := versions[version]; exist {
+			collisions = append(collisions, fmt.Sprintf("  %s: %s, %s", version, other, name))
+			continue
+		}
+		versions[version] = name
+	}
+	if len(collisions) > 0 {
+		return nil, fmt.Errorf("these migrations share a version, so history cannot be matched by version:\n%s", strings.Join(collisions, "\n"))
+	}
+	return versions, nil
+}
+
+// 引用记录表名，带 schema 的表名（如 public.flyway_schema_history）分段引用
+func quoteTableName(driver DatabaseDriver, table string) string {
+	parts := strings.Split(table, ".")
+	for i, part := range parts {
+		parts[i] = driver.QuoteIdentifier(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// 去掉版本号各段的前导 0，如 000001 -> 1，1.01 -> 1.1
+func normalizeVersion(version string) string {
+	parts := strings.Split(strings.TrimSpace(version), ".")
+	for i, part := range parts {
+		part = strings.TrimLeft(part, "0")
+		if part == "" {
+			part = "0"
+		}
+		parts[i] = part
+	}
+	return strings.Join(parts, ".")
+}
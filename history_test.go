@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImportLaravelTable(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"2014_10_12_000000_create_users_table.sql":          "CREATE TABLE users (id INT);",
+		"2014_10_12_000000_create_users_table_rollback.sql": "DROP TABLE users;",
+	})
+
+	for _, dryRun := range []bool{true, false} {
+		setupCommandTest(t, "import-history")
+		// Laravel 的 migrations 表没有 down_sql 字段
+		db := openTestSQLite(t, t.TempDir(),
+			"CREATE TABLE migrations (id INTEGER PRIMARY KEY AUTOINCREMENT, migration VARCHAR(255) NOT NULL, batch INTEGER NOT NULL)",
+			"INSERT INTO migrations (migration, batch) VALUES ('2014_10_12_000000_create_users_table', 1)",
+			"INSERT INTO migrations (migration, batch) VALUES ('2019_08_19_000000_create_failed_jobs_table', 2)",
+		)
+
+		err := importHistory(dir, []*DBConnection{db}, SourceLaravel, "", dryRun)
+		if err != nil {
+			t.Fatalf("dry run %v: %s (exit code %d)", dryRun, err, exitCode(err))
+		}
+
+		migrations := result.Databases[0].Migrations
+		if len(migrations) != 2 || migrations[0].Batch != 1 || len(migrations[1].Notes) != 1 || migrations[1].Notes[0] != "file_missing" {
+			t.Errorf("dry run %v: migrations = %+v", dryRun, migrations)
+		}
+		if len(result.Warnings) == 0 || !strings.Contains(result.Warnings[0], "down_sql column") {
+			t.Errorf("dry run %v: warnings = %q, want the down_sql column mentioned", dryRun, result.Warnings)
+		}
+		if missing := missingDownSQLColumn(db.DB); missing != dryRun {
+			t.Errorf("dry run %v: down_sql column missing = %v", dryRun, missing)
+		}
+	}
+}
+
+func TestReadHistory(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   HistorySource
+		table    string
+		setup    []string
+		versions map[string]string
+		want     []string
+		wantErr  string
+	}{
+		{
+			name: "laravel with a custom table", source: SourceLaravel, table: "old_migrations",
+			setup: []string{
+				"CREATE TABLE old_migrations (id INTEGER PRIMARY KEY, migration TEXT, batch INT)",
+				"INSERT INTO old_migrations (migration, batch) VALUES ('2014_10_12_000000_create_users_table', 1), ('2019_08_19_000000_create_jobs', 2)",
+			},
+			want: []string{"2014_10_12_000000_create_users_table@1", "2019_08_19_000000_create_jobs@2"},
+		},
+		{
+			name: "golang-migrate marks versions up to the current one", source: SourceGolangMigrate, table: "schema_migrations",
+			setup: []string{
+				"CREATE TABLE schema_migrations (version BIGINT, dirty BOOLEAN)",
+				"INSERT INTO schema_migrations VALUES (3, false)",
+			},
+			versions: map[string]string{"1": "a", "3": "c", "2": "b", "4": "d"},
+			want:     []string{"1@1", "2@1", "3@1"},
+		},
+		{
+			name: "golang-migrate without a version", source: SourceGolangMigrate, table: "schema_migrations",
+			setup:    []string{"CREATE TABLE schema_migrations (version BIGINT, dirty BOOLEAN)"},
+			versions: map[string]string{"1": "a"},
+			want:     []string{},
+		},
+		{
+			name: "golang-migrate dirty version", source: SourceGolangMigrate, table: "schema_migrations",
+			setup: []string{
+				"CREATE TABLE schema_migrations (version BIGINT, dirty BOOLEAN)",
+				"INSERT INTO schema_migrations VALUES (2, true)",
+			},
+			wantErr: "version 2 is dirty",
+		},
+		{
+			name: "goose keeps the latest row of each version", source: SourceGoose, table: "goose_db_version",
+			setup: []string{
+				"CREATE TABLE goose_db_version (id INTEGER PRIMARY KEY, version_id BIGINT, is_applied BOOLEAN)",
+				"INSERT INTO goose_db_version (version_id, is_applied) VALUES (0, true), (1, true), (2, true), (3, true), (2, false)",
+			},
+			want: []string{"1@1", "3@1"},
+		},
+		{
+			name: "flyway skips baselines, failures, repeatables and undone versions", source: SourceFlyway, table: "flyway_schema_history",
+			setup: []string{
+				"CREATE TABLE flyway_schema_history (installed_rank INT, version TEXT, description TEXT, type TEXT, success BOOLEAN)",
+				`INSERT INTO flyway_schema_history VALUES
+					(1, '1', '<< Flyway Baseline >>', 'BASELINE', true),
+					(2, '1.1', 'create users', 'SQL', true),
+					(3, '1.2', 'create posts', 'SQL', true),
+					(4, NULL, 'views', 'SQL', true),
+					(5, '1.3', 'broken', 'SQL', false),
+					(6, '1.2', 'create posts', 'UNDO_SQL', true),
+					(7, '1.10', 'add index', 'SQL', true)`,
+			},
+			want: []string{"1.1 create users@1", "1.10 add index@1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestSQLite(t, t.TempDir(), tt.setup...)
+			entries, err := readHistory(db, tt.source, quoteTableName(db.Driver, tt.table), tt.versions)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(entries))
+			for _, entry := range entries {
+				got = append(got, fmt.Sprintf("%s@%d", entry, entry.Batch))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entries = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportHistoryWithConvertMapping(t *testing.T) {
+	setupCommandTest(t, "import-history")
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"000001_create_users.sql":          "CREATE TABLE users (id INT);",
+		"000001_create_users_rollback.sql": "DROP TABLE users;",
+		"000002_add_index.sql":             "CREATE INDEX idx ON users (id);",
+		"000003_create_posts.sql":          "CREATE TABLE posts (id INT);",
+		ConvertMappingFileName: `{"from": "flyway", "migrations": [
+			{"source_version": "1.1", "source_file": "V1.1__create_users.sql", "migration": "000001_create_users"},
+			{"source_version": "1.10", "source_file": "V1.10__add_index.sql", "migration": "000002_add_index"},
+			{"source_version": "1.11", "source_file": "V1.11__create_posts.sql", "migration": "000003_create_posts"}
+		]}`,
+	})
+	db := openTestSQLite(t, dir,
+		"CREATE TABLE flyway_schema_history (installed_rank INT, version TEXT, description TEXT, type TEXT, success BOOLEAN)",
+		"INSERT INTO flyway_schema_history VALUES (1, '1.1', 'create users', 'SQL', true), (2, '1.010', 'add index', 'SQL', true)",
+	)
+
+	err := importHistory(dir, []*DBConnection{db}, SourceFlyway, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs, err := db.Driver.GetMigrationInfos(db.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, 0, len(recs))
+	for _, rec := range recs {
+		got = append(got, fmt.Sprintf("%s@%d %s", rec.Migration, rec.Batch, rec.DownSQL.String))
+	}
+	want := []string{"000001_create_users@1 DROP TABLE users;", "000002_add_index@1 "}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %q, want %q", got, want)
+	}
+
+	// 没有对应文件的记录会让导入停止
+	_, err = db.Exec("INSERT INTO flyway_schema_history VALUES (3, '2.0', 'gone', 'SQL', true)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = importHistory(dir, []*DBConnection{db}, SourceFlyway, "", false)
+	if exitCode(err) != ExitDrift || !strings.Contains(err.Error(), "2.0 gone") {
+		t.Errorf("err = %v (exit code %d), want a drift error naming 2.0", err, exitCode(err))
+	}
+}
+
+func TestLocalVersions(t *testing.T) {
+	versions, err := localVersions(newTestMigrations(
+		testMigration{name: "000001_a"}, testMigration{name: "20241118165301_b"}, testMigration{name: "no_version"},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"1": "000001_a", "20241118165301": "20241118165301_b"}
+	if !reflect.DeepEqual(versions, want) {
+		t.Errorf("versions = %v, want %v", versions, want)
+	}
+
+	_, err = localVersions(newTestMigrations(testMigration{name: "000001_a"}, testMigration{name: "1_b"}))
+	if err == nil || !strings.Contains(err.Error(), "1: 000001_a, 1_b") {
+		t.Errorf("err = %v, want the collision reported", err)
+	}
+}
+
+func TestNormalizeVersion(t *testing.T) {
+	tests := map[string]string{"000001": "1", "1.01": "1.1", "0": "0", "000": "0", " 2.0 ": "2.0", "1.10": "1.10"}
+	for version, want := range tests {
+		if got := normalizeVersion(version); got != want {
+			t.Errorf("normalizeVersion(%q) = %q, want %q", version, got, want)
+		}
+	}
+}
+
+func TestQuoteTableName(t *testing.T) {
+	tests := []struct {
+		driver DatabaseDriver
+		table  string
+		want   string
+	}{
+		{SQLiteDriver{}, "flyway_schema_history", `"flyway_schema_history"`},
+		{PostgreSQLDriver{}, "public.flyway_schema_history", `"public"."flyway_schema_history"`},
+		{MySQLDriver{}, "app.schema_migrations", "`app`.`schema_migrations`"},
+	}
+	for _, tt := range tests {
+		if got := quoteTableName(tt.driver, tt.table); got != tt.want {
+			t.Errorf("quoteTableName(%T, %q) = %s, want %s", tt.driver, tt.table, got, tt.want)
+		}
+	}
+}
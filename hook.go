@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+)
+
+// 钩子的触发时机
+type HookPoint string
+
+const (
+	HookBeforeRun       HookPoint = "before_run"       // run/rollback/seed 在数据库上开始前
+	HookAfterRun        HookPoint = "after_run"        // run/rollback/seed 在数据库上结束后，失败时也会执行
+	HookBeforeMigration HookPoint = "before_migration" // 每个 migration 执行或回滚前
+	HookAfterMigration  HookPoint = "after_migration"  // 每个 migration 执行或回滚后
+)
+
+var hookPoints = []HookPoint{HookBeforeRun, HookAfterRun, HookBeforeMigration, HookAfterMigration}
+
+// 会执行钩子的命令，seed 只执行 before_run/after_run
+var hookCommands = map[string]bool{
+	"run":      true,
+	"rollback": true,
+	"seed":     true,
+}
+
+// 可以执行 SQL 的 *sql.DB 或 *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// 钩子执行时的上下文，以环境变量的形式传给 shell 命令，以 template 变量的形式传给 SQL 文件
+type hookContext struct {
+	workDir    string
+	db         *DBConnection
+	span       *Span
+	batch      uint
+	migrations []string // 本次要执行或回滚的 migration
+	migration  string   // 仅 migration 前后的钩子
+	status     string   // 仅 after 钩子：success/failure
+}
+
+func (c hookContext) withMigration(name string, batch uint) hookContext {
+	c.migration, c.batch = name, batch
+	return c
+}
+
+func (c hookContext) withStatus(err error) hookContext {
+	c.status = "success"
+	if err != nil {
+		c.status = "failure"
+	}
+	return c
+}
+
+// 依次执行某个时机的钩子，遇到失败的钩子即停止。
+// SQL 钩子在 q 上执行，migration 前后的钩子传入事务，和 migration 一起提交或回滚
+func runHooks(point HookPoint, ctx hookContext, q execer) error {
+	if !hookCommands[result.Command] {
+		return nil
+	}
+	for _, hook := range config.Hooks.At(point) {
+		label := hook.Label()
+		logger.Info(fmt.Sprintf("    hook %s: %s", point, label))
+		attrs := []any{"blueprint.hook", label}
+		if ctx.migration != "" {
+			attrs = append(attrs, "blueprint.migration", ctx.migration)
+		}
+		span := tracer.StartSpan(ctx.span, "hook "+string(point), spanKindInternal, attrs...)
+		var err error
+		if hook.SQL != "" {
+			err = execSQLHook(hook.SQL, point, ctx, q)
+		} else {
+			err = execCommandHook(hook.Command, point, ctx)
+		}
+		span.End(err)
+		if err != nil {
+			return fmt.Errorf("%s hook `%s` failed: %w", point, label, err)
+		}
+	}
+	return nil
+}
+
+// SQL 文件先按 text/template 渲染，可以用 {{quote .Migration}} 这样的写法引用钩子变量
+func execSQLHook(filename string, point HookPoint, ctx hookContext, q execer) error {
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(ctx.workDir, filename)
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	tmpl, err := template.New(filepath.Base(filename)).Funcs(template.FuncMap{
+		"quote": quoteSQLString,
+	}).Parse(string(content))
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, ctx.vars(point))
+	if err != nil {
+		return err
+	}
+	for _, statement := range ctx.db.Driver.SplitStatements(buf.String()) {
+		logger.Debug("exec hook statement", "db", ctx.db.Config.Label(), "sql", statement)
+		_, err := q.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shell 命令在仓库目录下执行，输出和 Blueprint 自己的日志输出到同一处
+func execCommandHook(command string, point HookPoint, ctx hookContext) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Dir = ctx.workDir
+	cmd.Env = append(os.Environ(), ctx.environ(point)...)
+	cmd.Stdout = logger.out
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// 钩子变量，不包含数据库用户名和密码
+type hookVars struct {
+	Hook       string
+	Command    string
+	Direction  string // up/down
+	Env        string
+	Database   string
+	DBAlias    string
+	DBType     string
+	DBHost     string
+	DBPort     string
+	DBName     string // SQLite 为文件
+	Batch      string
+	Migrations []string
+	Migration  string // 仅 migration 前后的钩子
+	Status     string // 仅 after 钩子：success/failure
+}
+
+func (c hookContext) vars(point HookPoint) hookVars {
+	direction := "up"
+	if result.Command == "rollback" {
+		direction = "down"
+	}
+	name := c.db.Config.Name
+	if c.db.Config.Type == SQLite {
+		name = c.db.Config.File
+	}
+	port := ""
+	if c.db.Config.Port > 0 {
+		port = fmt.Sprint(c.db.Config.Port)
+	}
+	batch := ""
+	if c.batch > 0 {
+		batch = fmt.Sprint(c.batch)
+	}
+	return hookVars{
+		Hook:       string(point),
+		Command:    result.Command,
+		Direction:  direction,
+		Env:        config.Env,
+		Database:   c.db.Config.Label(),
+		DBAlias:    c.db.Config.Alias,
+		DBType:     string(c.db.Config.Type),
+		DBHost:     c.db.Config.Host,
+		DBPort:     port,
+		DBName:     name,
+		Batch:      batch,
+		Migrations: c.migrations,
+		Migration:  c.migration,
+		Status:     c.status,
+	}
+}
+
+// 传给 shell 钩子的环境变量
+func (c hookContext) environ(point HookPoint) []string {
+	v := c.vars(point)
+	return []string{
+		"BLUEPRINT_HOOK=" + v.Hook,
+		"BLUEPRINT_COMMAND=" + v.Command,
+		"BLUEPRINT_DIRECTION=" + v.Direction,
+		"BLUEPRINT_ENV=" + v.Env,
+		"BLUEPRINT_DATABASE=" + v.Database,
+		"BLUEPRINT_DB_ALIAS=" + v.DBAlias,
+		"BLUEPRINT_DB_TYPE=" + v.DBType,
+		"BLUEPRINT_DB_HOST=" + v.DBHost,
+		"BLUEPRINT_DB_PORT=" + v.DBPort,
+		"BLUEPRINT_DB_NAME=" + v.DBName,
+		"BLUEPRINT_BATCH=" + v.Batch,
+		"BLUEPRINT_MIGRATIONS=" + strings.Join(v.Migrations, ","),
+		"BLUEPRINT_MIGRATION=" + v.Migration,
+		"BLUEPRINT_STATUS=" + v.Status,
+	}
+}
+
+// 转成 SQL 字符串字面量
+func quoteSQLString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
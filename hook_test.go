@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestMigrationHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the command hook uses sh")
+	}
+	setupCommandTest(t, "run")
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"000001_create_users.sql": "CREATE TABLE users (id INT);",
+		"000002_create_posts.sql": "CREATE TABLE posts (id INT);",
+		"hooks/record.sql":        "INSERT INTO changes VALUES ({{quote .Migration}}, {{.Batch}}, {{quote .Direction}}, {{quote .Hook}});",
+	})
+	config.Hooks = &HooksConfig{
+		BeforeMigration: []HookConfig{{Command: `echo "$BLUEPRINT_HOOK $BLUEPRINT_MIGRATION $BLUEPRINT_BATCH $BLUEPRINT_DIRECTION" >> hook.log`}},
+		AfterMigration:  []HookConfig{{SQL: "hooks/record.sql"}},
+	}
+	db := openTestSQLite(t, dir, "CREATE TABLE changes (migration TEXT, batch INT, direction TEXT, hook TEXT)")
+
+	err := runMigration(dir, []*DBConnection{db}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "hook.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLog := "before_migration 000001_create_users 1 up\nbefore_migration 000002_create_posts 1 up\n"
+	if string(content) != wantLog {
+		t.Errorf("hook.log = %q, want %q", content, wantLog)
+	}
+
+	rows, err := db.Query("SELECT migration, batch, direction, hook FROM changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	got := make([]string, 0)
+	for rows.Next() {
+		var migration, batch, direction, hook string
+		if err := rows.Scan(&migration, &batch, &direction, &hook); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, strings.Join([]string{migration, batch, direction, hook}, " "))
+	}
+	want := []string{"000001_create_users 1 up after_migration", "000002_create_posts 1 up after_migration"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changes = %q, want %q", got, want)
+	}
+}
+
+func TestQuoteSQLString(t *testing.T) {
+	tests := map[string]string{
+		"":                    "''",
+		"000001_create_users": "'000001_create_users'",
+		"it's":                "'it''s'",
+	}
+	for s, want := range tests {
+		if got := quoteSQLString(s); got != want {
+			t.Errorf("quoteSQLString(%q) = %s, want %s", s, got, want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelVerbose
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelVerbose:
+		return "verbose"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	}
+	return "error"
+}
+
+type LogFormat string
+
+const (
+	LogText   LogFormat = "text"
+	LogLogfmt LogFormat = "logfmt"
+	LogJSON   LogFormat = "json"
+)
+
+func (f LogFormat) IsValid() bool {
+	switch f {
+	case LogText, LogLogfmt, LogJSON:
+		return true
+	}
+	return false
+}
+
+const redacted = "******"
+
+// 分级输出日志，text 格式和原来的输出一致，logfmt 和 json 格式带时间戳
+type Logger struct {
+	level   LogLevel
+	format  LogFormat
+	out     io.Writer
+	secrets []string
+}
+
+var logger = &Logger{
+	level:  LevelInfo,
+	format: LogText,
+	out:    os.Stdout,
+}
+
+// 短于这个长度的敏感值只在整个值相同时隐去，否则像 "root" 这样的密码会把所有 root 都替换掉。
+// 密码本身不会被输出：驱动错误中的 DSN 由 redactDSN 隐去，连接日志中不带密码
+const minSecretLength = 6
+
+// 登记不能出现在日志中的值，如数据库密码
+func (l *Logger) AddSecret(secret string) {
+	if secret != "" {
+		l.secrets = append(l.secrets, secret)
+	}
+}
+
+// 隐去 s 中登记过的敏感值
+func (l *Logger) Redact(s string) string {
+	for _, secret := range l.secrets {
+		if utf8.RuneCountInString(secret) < minSecretLength {
+			if s == secret {
+				return redacted
+			}
+			continue
+		}
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return s
+}
+
+func (l *Logger) Debug(msg string, kv ...any)   { l.log(LevelDebug, msg, kv...) }
+func (l *Logger) Verbose(msg string, kv ...any) { l.log(LevelVerbose, msg, kv...) }
+func (l *Logger) Info(msg string, kv ...any)    { l.log(LevelInfo, msg, kv...) }
+func (l *Logger) Warn(msg string, kv ...any)    { l.log(LevelWarn, msg, kv...) }
+func (l *Logger) Error(msg string, kv ...any)   { l.log(LevelError, msg, kv...) }
+
+func (l *Logger) log(level LogLevel, msg string, kv ...any) {
+	if level < l.level {
+		return
+	}
+
+	msg = l.Redact(msg)
+	keys := make([]string, 0, len(kv)/2)
+	values := make([]string, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		keys = append(keys, fmt.Sprint(kv[i]))
+		values = append(values, l.Redact(fmt.Sprint(kv[i+1])))
+	}
+
+	var line strings.Builder
+	switch l.format {
+	case LogLogfmt:
+		line.WriteString("time=" + time.Now().Format(time.RFC3339Nano))
+		line.WriteString(" level=" + level.String())
+		line.WriteString(" msg=" + logfmtValue(msg))
+		for i := range keys {
+			line.WriteString(" " + keys[i] + "=" + logfmtValue(values[i]))
+		}
+
+	case LogJSON:
+		line.WriteString(`{"time":` + strconv.Quote(time.Now().Format(time.RFC3339Nano)))
+		line.WriteString(`,"level":` + strconv.Quote(level.String()))
+		msgJSON, _ := json.Marshal(msg)
+		line.WriteString(`,"msg":` + string(msgJSON))
+		for i := range keys {
+			keyJSON, _ := json.Marshal(keys[i])
+			valueJSON, _ := json.Marshal(values[i])
+			line.WriteString("," + string(keyJSON) + ":" + string(valueJSON))
+		}
+		line.WriteString("}")
+
+	default:
+		switch level {
+		case LevelWarn:
+			line.WriteString("Warning: ")
+		case LevelError:
+			line.WriteString("Error: ")
+		}
+		line.WriteString(msg)
+		for i := range keys {
+			line.WriteString(" " + keys[i] + "=" + logfmtValue(values[i]))
+		}
+	}
+
+	fmt.Fprintln(l.out, line.String())
+}
+
+func logfmtValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"\t\r\n") {
+		return strconv.Quote(value)
+	}
+	return value
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	l := &Logger{}
+	l.AddSecret("s3cret-password")
+	l.AddSecret("root")
+	l.AddSecret("")
+
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"long secret inside a message", "auth failed with s3cret-password for app", "auth failed with ****** for app"},
+		{"long secret repeated", "s3cret-password,s3cret-password", "******,******"},
+		{"short secret as a whole value", "root", "******"},
+		{"short secret inside words is kept", "user root on rootfs", "user root on rootfs"},
+		{"nothing to redact", "connect to db[sqlite app.db]", "connect to db[sqlite app.db]"},
+		{"empty string", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := l.Redact(tt.s); got != tt.want {
+				t.Errorf("Redact(%q) = %q, want %q", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogRedactsValues(t *testing.T) {
+	var out bytes.Buffer
+	l := &Logger{level: LevelDebug, format: LogLogfmt, out: &out}
+	l.AddSecret("pw")
+	l.AddSecret("Bearer abcdefgh")
+	l.Debug("sending webhook", "authorization", "Bearer abcdefgh", "pass", "pw", "user", "pwuser")
+
+	line := out.String()
+	for _, leak := range []string{"abcdefgh", "pass=pw"} {
+		if strings.Contains(line, leak) {
+			t.Errorf("log line %q leaks %q", line, leak)
+		}
+	}
+	if !strings.Contains(line, "user=pwuser") {
+		t.Errorf("log line %q lost an unrelated value", line)
+	}
+}
+
+func TestRedactDSN(t *testing.T) {
+	dsn := "postgres://app:pw@db%zz:5432/app"
+	safeDSN := "postgres://app:******@db%zz:5432/app"
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"dsn in the message", errors.New("parse " + dsn + ": invalid"), "parse " + safeDSN + ": invalid"},
+		{"quoted dsn", errors.New(`parse "` + dsn + `": invalid URL escape "%zz"`), `parse "` + safeDSN + `": invalid URL escape "%zz"`},
+		{"no dsn", errors.New("dial tcp 127.0.0.1:5432: connect: connection refused"), "dial tcp 127.0.0.1:5432: connect: connection refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactDSN(tt.err, dsn, safeDSN).Error(); got != tt.want {
+				t.Errorf("redactDSN() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,12 +1,10 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime/debug"
-	"strconv"
-	"strings"
 )
 
 var version = "dev"
@@ -31,29 +29,37 @@ func echoVersion() {
 	fmt.Println()
 }
 
-func echoHelp() {
-	fmt.Println(`Commands:`)
-	fmt.Println(`  init                Init a Blueprint repo in current work directory`)
-	fmt.Println(`  run                 Exec migrations`)
-	fmt.Println(`  create, update      Create a pair(include rollback) migration sql files`)
-	fmt.Println(`  dump               Dump schema from database`)
-	fmt.Println(`  rollback           Rollback`)
-	fmt.Println(`                        --step  specify how many step(s) for rollback`)
-	fmt.Println(`                        --batch specify how many batch(es) for rollback`)
-	fmt.Println(`                        Only one of --step or --batch can be specified at a time,`)
-	fmt.Println(`                        default is --batch 1`)
-	fmt.Println(`  help                Display this infomation`)
-}
-
 var dbs []*DBConnection // 数据库连接
 
+var showBanner = true
+
+var repoDir string // Blueprint 仓库目录
+
 func bootstrap(workDir string) {
 	err := loadJsonConfig(workDir)
 	if err != nil {
-		fmt.Println("Parse config failed:", err.Error())
-		os.Exit(1)
+		fail(errorf(ExitConfig, "parse config failed: %s", err.Error()))
+	}
+	err = selectDatabases(dbAliases)
+	if err != nil {
+		fail(err)
 	}
 
+	for _, dbCnf := range config.Databases {
+		logger.AddSecret(dbCnf.Pass)
+	}
+	for _, webhook := range config.Webhooks {
+		for _, value := range webhook.Headers {
+			logger.AddSecret(value)
+		}
+	}
+	if config.Tracing != nil {
+		for _, value := range config.Tracing.Headers {
+			logger.AddSecret(value)
+		}
+	}
+	tracer.Enable(config.Tracing)
+
 	for _, dbCnf := range config.Databases {
 		var driver DatabaseDriver
 		var dbName string
@@ -63,25 +69,34 @@ func bootstrap(workDir string) {
 			dbName = dbCnf.Name
 		case SQLite:
 			driver = SQLiteDriver{}
+			// 相对路径相对于仓库目录，使用 --dir 时也能找到
 			dbName = dbCnf.File
+			if !filepath.IsAbs(dbName) {
+				dbName = filepath.Join(workDir, dbName)
+			}
 		case MySQL:
 			fallthrough
 		case "":
 			driver = MySQLDriver{}
 			dbName = dbCnf.Name
 		default:
-			fmt.Printf("unsupported database type: %s\n", dbCnf.Type)
-			os.Exit(1)
+			fail(errorf(ExitConfig, "unsupported database type: %s", dbCnf.Type))
 		}
 
+		logger.Verbose(fmt.Sprintf("connecting to db[%s]", dbCnf.Label()), "user", dbCnf.User, "pass", redacted)
 		db, err := driver.Connect(dbCnf.Host, dbCnf.Port, dbCnf.User, dbCnf.Pass, dbName)
 		if err != nil {
-			fmt.Printf("connect to db[%s] error: %s\n", dbCnf.Host, err)
-			os.Exit(1)
+			err = errorf(ExitDatabase, "connect to db[%s] error: %s", dbCnf.Label(), err)
+			// 连接失败的数据库也要出现在结果中，审计、webhook 和指标才能记下这次失败
+			dbResult := result.AddDatabase(&DBConnection{Driver: driver, Config: dbCnf})
+			dbResult.conn = nil // 没有可用的连接，之后不再读写这个数据库
+			dbResult.Error = err.Error()
+			fail(err)
 		}
 		dbs = append(dbs, &DBConnection{
 			DB:     db,
 			Driver: driver,
+			Config: dbCnf,
 		})
 	}
 }
@@ -92,95 +107,33 @@ func cleanup() {
 	}
 }
 
-func main() {
-	echoVersion()
-
-	cwd, err := os.Getwd()
-	if err != nil {
-		fmt.Println("Get cwd failed:", err.Error())
-		return
+// 输出错误并退出
+func fail(err error) {
+	writeAudit(repoDir, err)
+	notifyFinished(err)
+	finishTracing(err)
+	writeMetrics(repoDir, err)
+	writeReport(err)
+	cleanup()
+	if outputFormat == OutputJSON {
+		logger.out = os.Stderr
+		printResult(err)
 	}
+	logger.Error(err.Error())
+	os.Exit(int(exitCode(err)))
+}
 
-	args := os.Args
-	if len(args) == 1 {
-		bootstrap(cwd)
-		defer cleanup()
-		err = runMigration(cwd, dbs)
-	} else {
-		action := strings.ToLower(args[1])
-		params := args[2:]
-
-		switch action {
-		case "init":
-			err = initBlueprint(cwd)
-
-		case "run":
-			bootstrap(cwd)
-			defer cleanup()
-			err = runMigration(cwd, dbs)
-
-		case "create",
-			"update":
-			err = createMigration(cwd, action, params)
-
-		case "dump":
-			bootstrap(cwd)
-			defer cleanup()
-			forceDump := false
-			for _, param := range params {
-				if param == "--force" {
-					forceDump = true
-				}
-			}
-			err = dumpSchemas(dbs[0], cwd, forceDump)
-
-		case "rollback":
-			bootstrap(cwd)
-			defer cleanup()
-			step := 0
-			batch := 0
-			for idx := 0; idx < len(params); idx++ {
-				param := params[idx]
-				if param == "--step" || param == "--batch" {
-					if idx+1 >= len(params) {
-						err = errors.New("invalid param: " + param)
-						break
-					}
-					value := 0
-					value, err = strconv.Atoi(params[idx+1])
-					if err != nil {
-						break
-					}
-					if value < 1 {
-						err = errors.New("invalid param value: " + param + " = " + params[idx+1])
-						break
-					}
-					switch param {
-					case "--step":
-						step = value
-					case "--batch":
-						batch = value
-					}
-					idx++
-				}
-			}
-			if step != 0 && batch != 0 {
-				err = errors.New("only one of --step or --batch can be specified at a time")
-			}
-			if err == nil {
-				err = rollbackMigration(cwd, dbs, step, batch)
-			}
-
-		case "help":
-			fallthrough
-		default:
-			echoHelp()
-			err = nil
-		}
-	}
+func main() {
+	defer cleanup()
 
+	err := runCommandLine(os.Args[1:])
 	if err != nil {
-		fmt.Println("Error:", err)
-		os.Exit(1)
+		fail(err)
 	}
+	writeAudit(repoDir, nil)
+	notifyFinished(nil)
+	finishTracing(nil)
+	writeMetrics(repoDir, nil)
+	writeReport(nil)
+	printResult(nil)
 }
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 这些命令执行后输出 Prometheus 指标
+var metricsCommands = map[string]bool{
+	"run":      true,
+	"rollback": true,
+	"status":   true,
+}
+
+// 只有这些命令会修改表结构，失败次数和最近成功时间只统计它们，status 只是读取
+var outcomeCommands = map[string]bool{
+	"run":      true,
+	"rollback": true,
+}
+
+var (
+	metricsFile    string // --metrics-file，node-exporter 的 textfile 格式
+	pushgatewayURL string // --pushgateway
+)
+
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string // gauge/counter
+	samples []metricSample
+}
+
+type metricSample struct {
+	labels string // 渲染后的 label，如 {database="sqlite app.db"}
+	value  float64
+}
+
+// 把命令的指标写入 metrics 文件和/或推送到 pushgateway。
+// 计数和最近成功时间从上一次的 metrics 文件延续，没有 metrics 文件时从 pushgateway 读回
+func writeMetrics(workDir string, cmdErr error) {
+	if metricsFile == "" && pushgatewayURL == "" {
+		return
+	}
+	if !metricsCommands[result.Command] {
+		return
+	}
+
+	previous := map[string]float64{}
+	var err error
+	if metricsFile != "" {
+		previous, err = readMetricsFile(metricsFile)
+		if err != nil {
+			warnf("read metrics file %s failed: %s", metricsFile, err)
+		}
+	} else {
+		previous, err = readPushgateway(pushgatewayURL)
+		if err != nil {
+			warnf("read metrics from %s failed, counters start over: %s", webhookLabel(pushgatewayURL), err)
+		}
+	}
+
+	content := buildMetrics(workDir, cmdErr, previous)
+
+	if metricsFile != "" {
+		err := writeMetricsFile(metricsFile, content)
+		if err != nil {
+			warnf("write metrics file %s failed: %s", metricsFile, err)
+		}
+	}
+	if pushgatewayURL != "" {
+		err := pushMetrics(pushgatewayURL, content)
+		if err != nil {
+			warnf("push metrics to %s failed: %s", webhookLabel(pushgatewayURL), err)
+		}
+	}
+}
+
+func buildMetrics(workDir string, cmdErr error, previous map[string]float64) []byte {
+	pending := &metricFamily{name: "blueprint_pending_migrations", kind: "gauge",
+		help: "Number of migration files not applied to the database."}
+	maxBatch := &metricFamily{name: "blueprint_applied_batch_max", kind: "gauge",
+		help: "Highest batch number applied to the database."}
+	success := &metricFamily{name: "blueprint_last_run_success", kind: "gauge",
+		help: "Whether the last command on the database succeeded (1) or failed (0)."}
+	duration := &metricFamily{name: "blueprint_last_run_duration_seconds", kind: "gauge",
+		help: "Duration of the last command on the database."}
+	executed := &metricFamily{name: "blueprint_last_run_migrations", kind: "gauge",
+		help: "Number of migrations executed by the last command on the database."}
+	lastSuccess := &metricFamily{name: "blueprint_last_success_timestamp_seconds", kind: "gauge",
+		help: "Unix time of the last successful command on the database."}
+	failures := &metricFamily{name: "blueprint_failures_total", kind: "counter",
+		help: "Number of failed commands on the database."}
+
+	migrations, err := LoadMigrations(workDir, config.Hooks)
+	if err != nil {
+		warnf("load migrations for metrics failed: %s", err)
+	}
+
+	now := time.Now()
+	for _, dbResult := range result.Databases {
+		labels := metricLabels("database", dbResult.Database, "type", string(dbResult.Type))
+		commandLabels := metricLabels("database", dbResult.Database, "type", string(dbResult.Type), "command", result.Command)
+		failed := result.DatabaseError(dbResult, cmdErr) != ""
+
+		// 命令成功时记录已经变了，重新读取
+		if dbResult.conn != nil && migrations != nil {
+			recs, err := dbResult.conn.Driver.GetMigrationInfos(dbResult.conn.DB)
+			if err == nil {
+				applied := make(map[string]struct{})
+				batch := uint(0)
+				for _, rec := range recs {
+					applied[rec.Migration] = struct{}{}
+					if rec.Batch > batch {
+						batch = rec.Batch
+					}
+				}
+				count := 0
+				for _, name := range migrations.GetNames() {
+					if _, exist := applied[name]; !exist && migrations.GetInfo(name).AppliesTo(config.Env) {
+						count++
+					}
+				}
+				pending.add(labels, float64(count))
+				maxBatch.add(labels, float64(batch))
+			}
+		}
+
+		count := 0
+		for _, m := range dbResult.Migrations {
+			switch m.Status {
+			case StatusApplied, StatusRolledBack, StatusFailed:
+				if len(m.Statements) > 0 {
+					count++
+				}
+			}
+		}
+
+		if failed {
+			success.add(commandLabels, 0)
+		} else {
+			success.add(commandLabels, 1)
+		}
+		if outcomeCommands[result.Command] {
+			failureCount := previous[failures.name+labels]
+			if failed {
+				failureCount++
+			} else {
+				lastSuccess.add(labels, float64(now.Unix()))
+			}
+			failures.add(labels, failureCount)
+		}
+		duration.add(commandLabels, now.Sub(dbResult.started).Seconds())
+		executed.add(commandLabels, float64(count))
+	}
+
+	var buf bytes.Buffer
+	for _, family := range []*metricFamily{pending, maxBatch, success, duration, executed, lastSuccess, failures} {
+		family.keep(previous)
+		if len(family.samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(&buf, "# HELP %s %s\n", family.name, family.help)
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", family.name, family.kind)
+		for _, sample := range family.samples {
+			fmt.Fprintf(&buf, "%s%s %s\n", family.name, sample.labels, strconv.FormatFloat(sample.value, 'g', -1, 64))
+		}
+	}
+	return buf.Bytes()
+}
+
+func (f *metricFamily) add(labels string, value float64) {
+	f.samples = append(f.samples, metricSample{labels: labels, value: value})
+}
+
+// 保留上次有、这次没有更新的样本，如本次没有涉及的数据库或命令
+func (f *metricFamily) keep(previous map[string]float64) {
+	updated := make(map[string]struct{})
+	for _, sample := range f.samples {
+		updated[sample.labels] = struct{}{}
+	}
+	keys := make([]string, 0)
+	for key := range previous {
+		labels, found := strings.CutPrefix(key, f.name)
+		if !found || !strings.HasPrefix(labels, "{") {
+			continue
+		}
+		if _, exist := updated[labels]; !exist {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		f.add(strings.TrimPrefix(key, f.name), previous[key])
+	}
+}
+
+// 按名称排序渲染 label，相同的 label 总是得到相同的结果
+func metricLabels(kv ...string) string {
+	pairs := make([]string, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(kv[i+1])
+		pairs = append(pairs, kv[i]+`="`+value+`"`)
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// 读取上一次命令写入的 metrics 文件，key 为指标名加 label
+func readMetricsFile(filename string) (map[string]float64, error) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return make(map[string]float64), nil
+	}
+	if err != nil {
+		return make(map[string]float64), err
+	}
+	defer f.Close()
+	return parseMetrics(f)
+}
+
+// 解析 Prometheus 文本格式的样本，key 为指标名加渲染后的 label
+func parseMetrics(r io.Reader) (map[string]float64, error) {
+	samples := make(map[string]float64)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		idx := strings.LastIndex(line, " ")
+		if idx < 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[idx+1:], 64)
+		if err != nil {
+			continue
+		}
+		samples[line[:idx]] = value
+	}
+	return samples, scanner.Err()
+}
+
+// 原子替换文件，node-exporter 不会读到写了一半的文件
+func writeMetricsFile(filename string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// 推送的 URL，没有 /metrics/ 路径时使用默认的 job
+func pushgatewayJobURL(rawURL string) string {
+	if !strings.Contains(rawURL, "/metrics/") {
+		rawURL = strings.TrimSuffix(rawURL, "/") + "/metrics/job/blueprint"
+	}
+	return rawURL
+}
+
+// 替换 pushgateway 上该 group 的指标
+func pushMetrics(rawURL string, content []byte) error {
+	req, err := http.NewRequest(http.MethodPut, pushgatewayJobURL(rawURL), bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if urlErr, ok := err.(*url.Error); ok {
+		return urlErr.Err
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// 读取上次推送到该 group 的样本，key 和 readMetricsFile 一致。
+// pushgateway 会给每个样本加上 grouping label 和空的 instance label，这里再去掉
+func readPushgateway(rawURL string) (map[string]float64, error) {
+	u, err := url.Parse(pushgatewayJobURL(rawURL))
+	if err != nil {
+		return nil, err
+	}
+	base, groupPath, _ := strings.Cut(u.Path, "/metrics/")
+	segments := strings.Split(strings.Trim(groupPath, "/"), "/")
+	grouping := make(map[string]string)
+	for i := 0; i+1 < len(segments); i += 2 {
+		grouping[segments[i]] = segments[i+1]
+	}
+	if _, exist := grouping["instance"]; !exist {
+		grouping["instance"] = ""
+	}
+	u.Path, u.RawPath, u.RawQuery = base+"/metrics", "", ""
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(u.String())
+	if urlErr, ok := err.(*url.Error); ok {
+		return nil, urlErr.Err
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	all, err := parseMetrics(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make(map[string]float64)
+	for key, value := range all {
+		name, rawLabels, found := strings.Cut(key, "{")
+		if !found {
+			continue
+		}
+		labels, ok := parseMetricLabels(strings.TrimSuffix(rawLabels, "}"))
+		if !ok {
+			continue
+		}
+		// 跳过其他 group（如其他 job）的样本，和 pushgateway 一样缺少的 label 视为空
+		values := make(map[string]string, len(labels))
+		kv := make([]string, 0, len(labels)*2)
+		for _, pair := range labels {
+			if _, exist := grouping[pair[0]]; exist {
+				values[pair[0]] = pair[1]
+				continue
+			}
+			kv = append(kv, pair[0], pair[1])
+		}
+		ours := true
+		for label, groupValue := range grouping {
+			if values[label] != groupValue {
+				ours = false
+			}
+		}
+		if !ours {
+			continue
+		}
+		samples[name+metricLabels(kv...)] = value
+	}
+	return samples, nil
+}
+
+// 解析去掉花括号的 label，如 database="sqlite app.db",type="sqlite"
+func parseMetricLabels(s string) ([][2]string, bool) {
+	labels := make([][2]string, 0)
+	for s != "" {
+		name, rest, found := strings.Cut(s, `="`)
+		if !found {
+			return nil, false
+		}
+		var value strings.Builder
+		i := 0
+		for ; i < len(rest) && rest[i] != '"'; i++ {
+			if rest[i] == '\\' && i+1 < len(rest) {
+				i++
+				switch rest[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(rest[i])
+				}
+				continue
+			}
+			value.WriteByte(rest[i])
+		}
+		if i >= len(rest) {
+			return nil, false
+		}
+		labels = append(labels, [2]string{strings.TrimSpace(name), value.String()})
+		s = strings.TrimPrefix(rest[i+1:], ",")
+	}
+	return labels, true
+}
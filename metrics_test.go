@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMetrics(t *testing.T) {
+	content := `# HELP blueprint_failures_total Number of failed commands on the database.
+# TYPE blueprint_failures_total counter
+blueprint_failures_total{database="sqlite app.db",type="sqlite"} 3
+
+blueprint_last_success_timestamp_seconds{database="sqlite app.db",type="sqlite"} 1.7e+09
+blueprint_last_run_success{command="run",database="a b",type="mysql"} 0
+broken_line
+not_a_number{a="b"} NaN?
+`
+	samples, err := parseMetrics(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{
+		`blueprint_failures_total{database="sqlite app.db",type="sqlite"}`:                 3,
+		`blueprint_last_success_timestamp_seconds{database="sqlite app.db",type="sqlite"}`: 1.7e9,
+		`blueprint_last_run_success{command="run",database="a b",type="mysql"}`:            0,
+	}
+	if !reflect.DeepEqual(samples, want) {
+		t.Errorf("samples = %v, want %v", samples, want)
+	}
+}
+
+func TestMetricLabels(t *testing.T) {
+	tests := []struct {
+		kv   []string
+		want string
+	}{
+		{[]string{"type", "sqlite", "database", "sqlite app.db"}, `{database="sqlite app.db",type="sqlite"}`},
+		{[]string{"database", `a"b\c` + "\n"}, `{database="a\"b\\c\n"}`},
+		{[]string{"database"}, `{}`},
+		{nil, `{}`},
+	}
+	for _, tt := range tests {
+		if got := metricLabels(tt.kv...); got != tt.want {
+			t.Errorf("metricLabels(%q) = %s, want %s", tt.kv, got, tt.want)
+		}
+	}
+}
+
+func TestParseMetricLabels(t *testing.T) {
+	tests := []struct {
+		s      string
+		want   [][2]string
+		wantOK bool
+	}{
+		{`database="sqlite app.db",type="sqlite"`, [][2]string{{"database", "sqlite app.db"}, {"type", "sqlite"}}, true},
+		{`database="a\"b\\c\n",instance=""`, [][2]string{{"database", "a\"b\\c\n"}, {"instance", ""}}, true},
+		{``, [][2]string{}, true},
+		{`database="unterminated`, nil, false},
+		{`database`, nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseMetricLabels(tt.s)
+		if ok != tt.wantOK || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseMetricLabels(%q) = %q, %v, want %q, %v", tt.s, got, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	// 渲染后再解析得到原来的值
+	rendered := metricLabels("database", `x"y\z`+"\n", "type", "pg")
+	labels, ok := parseMetricLabels(strings.Trim(rendered, "{}"))
+	if !ok || labels[0][1] != `x"y\z`+"\n" || labels[1][1] != "pg" {
+		t.Errorf("round trip of %s = %q, %v", rendered, labels, ok)
+	}
+}
+
+func TestPushgatewayJobURL(t *testing.T) {
+	tests := map[string]string{
+		"http://pushgateway:9091":                             "http://pushgateway:9091/metrics/job/blueprint",
+		"http://pushgateway:9091/":                            "http://pushgateway:9091/metrics/job/blueprint",
+		"http://pushgateway:9091/metrics/job/deploy":          "http://pushgateway:9091/metrics/job/deploy",
+		"http://pushgateway:9091/metrics/job/deploy/env/prod": "http://pushgateway:9091/metrics/job/deploy/env/prod",
+	}
+	for rawURL, want := range tests {
+		if got := pushgatewayJobURL(rawURL); got != want {
+			t.Errorf("pushgatewayJobURL(%q) = %q, want %q", rawURL, got, want)
+		}
+	}
+}
+
+func TestReadPushgateway(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/prefix/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`# TYPE blueprint_failures_total counter
+blueprint_failures_total{database="sqlite app.db",env="prod",instance="",job="deploy",type="sqlite"} 2
+blueprint_failures_total{database="sqlite app.db",env="dev",instance="",job="deploy",type="sqlite"} 5
+blueprint_failures_total{database="sqlite app.db",env="prod",instance="",job="other",type="sqlite"} 7
+blueprint_failures_total{database="sqlite app.db",env="prod",instance="host1",job="deploy",type="sqlite"} 9
+push_time_seconds{env="prod",instance="",job="deploy"} 1.7e+09
+up 1
+`))
+	}))
+	t.Cleanup(server.Close)
+
+	samples, err := readPushgateway(server.URL + "/prefix/metrics/job/deploy/env/prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{
+		`blueprint_failures_total{database="sqlite app.db",type="sqlite"}`: 2,
+		`push_time_seconds{}`: 1.7e9,
+	}
+	if !reflect.DeepEqual(samples, want) {
+		t.Errorf("samples = %v, want %v", samples, want)
+	}
+
+	_, err = readPushgateway(server.URL + "/missing")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("err = %v, want the status reported", err)
+	}
+}
+
+func TestWriteMetricsKeepsCounters(t *testing.T) {
+	setupCommandTest(t, "run")
+	dir := t.TempDir()
+	saved := metricsFile
+	t.Cleanup(func() { metricsFile = saved })
+	metricsFile = filepath.Join(dir, "blueprint.prom")
+	db := openTestSQLite(t, dir)
+	labels := metricLabels("database", db.Config.Label(), "type", string(SQLite))
+	// 上次的计数和其他数据库的记录都要保留
+	writeTestFiles(t, dir, map[string]string{
+		"blueprint.prom": "blueprint_failures_total" + labels + " 2\n" +
+			`blueprint_failures_total{database="mysql other",type="mysql"} 4` + "\n" +
+			"blueprint_last_success_timestamp_seconds" + labels + " 100\n",
+	})
+
+	result.AddDatabase(db)
+	writeMetrics(dir, errors.New("exec failed"))
+
+	samples, err := readMetricsFile(metricsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]float64{
+		"blueprint_failures_total" + labels:                                                                                  3,
+		`blueprint_failures_total{database="mysql other",type="mysql"}`:                                                      4,
+		"blueprint_last_success_timestamp_seconds" + labels:                                                                  100,
+		"blueprint_last_run_success" + metricLabels("database", db.Config.Label(), "type", string(SQLite), "command", "run"): 0,
+	} {
+		if samples[key] != want {
+			t.Errorf("%s = %v, want %v", key, samples[key], want)
+		}
+	}
+}
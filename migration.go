@@ -1,8 +1,15 @@
 package main
 
 import (
+	"database/sql"
+	"fmt"
+	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -11,12 +18,18 @@ type MigrationRec struct {
 	Id        uint
 	Migration string
 	Batch     uint
+	DownSQL   sql.NullString // 执行时保存的回滚 SQL
 }
 
 type MigrationInfo struct {
 	Name         string
 	UpFilename   string
 	DownFilename string
+	SingleFile   bool           // up/down 写在同一个文件中，用 -- +blueprint Up/Down 分隔
+	DependsOn    []string       // 必须在这些 migration 之后执行，用 -- +blueprint depends-on: 声明
+	Envs         []string       // 只在这些环境中执行，用 -- +blueprint env: 声明，为空时不限制
+	Phase        MigrationPhase // 零停机部署的阶段，用 -- +blueprint phase: 声明
+	Irreversible bool           // 不可回滚，用 -- +blueprint irreversible 声明
 
 	upSQL   string
 	downSQL string
@@ -27,8 +40,18 @@ func (m *MigrationInfo) LoadSQLFile() error {
 	if err != nil {
 		return err
 	}
+	if m.SingleFile {
+		m.upSQL, m.downSQL = splitSingleFile(string(up))
+		return nil
+	}
 	m.upSQL = string(up)
 
+	// 没有回滚文件的 migration 是不可回滚的
+	if m.DownFilename == "" {
+		m.downSQL = ""
+		return nil
+	}
+
 	down, err := os.ReadFile(m.DownFilename)
 	if err != nil {
 		return err
@@ -38,6 +61,37 @@ func (m *MigrationInfo) LoadSQLFile() error {
 	return nil
 }
 
+// 是否用 -- +blueprint irreversible 声明为不可回滚。
+// 没有声明、但回滚文件缺失或为空的 migration 不算，回滚时会作为错误报告
+func (m MigrationInfo) IsIrreversible() bool {
+	return m.Irreversible
+}
+
+// 是否需要在 env 环境中执行
+func (m MigrationInfo) AppliesTo(env string) bool {
+	if len(m.Envs) == 0 {
+		return true
+	}
+	for _, e := range m.Envs {
+		if strings.EqualFold(e, env) {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断 SQL 是否只包含空白和注释
+func IsEmptySQL(content string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "--") || line == ";" {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
 func (m MigrationInfo) GetUpSQL() string {
 	return m.upSQL
 }
@@ -59,26 +113,44 @@ func (m *Migrations) GetInfo(name string) MigrationInfo {
 	return m.infos[name]
 }
 
-func LoadMigrations(migrationPath string) (*Migrations, error) {
-	dirs, err := os.ReadDir(migrationPath)
-	if err != nil {
-		return nil, err
-	}
+func (m *Migrations) Exists(name string) bool {
+	_, exist := m.infos[name]
+	return exist
+}
 
+// 读取目录下的全部 migration，hooks 中钩子使用的 SQL 文件不是 migration，会被跳过
+func LoadMigrations(migrationPath string, hooks *HooksConfig) (*Migrations, error) {
 	migrations := &Migrations{
 		names: make([]string, 0),
 		infos: make(map[string]MigrationInfo),
 	}
 
-	for _, dir := range dirs {
+	hookFiles := hooks.SQLFiles(migrationPath)
+
+	// 递归读取子目录，migration 名称只取文件名，与所在目录无关
+	err := filepath.WalkDir(migrationPath, func(filePath string, dir fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
 		if dir.IsDir() {
-			continue
+			// 跳过隐藏目录，如 .git
+			if filePath != migrationPath && strings.HasPrefix(dir.Name(), ".") {
+				return filepath.SkipDir
+			}
+			// seed 不是 migration
+			if filePath == filepath.Join(migrationPath, SeedsDirName) {
+				return filepath.SkipDir
+			}
+			return nil
 		}
 
 		filename := dir.Name()
 		fileExt := strings.ToLower(path.Ext(filename))
 		if fileExt != ".sql" {
-			continue
+			return nil
+		}
+		if _, exist := hookFiles[filePath]; exist {
+			return nil
 		}
 
 		migrationName := filename[:len(filename)-4]
@@ -96,14 +168,313 @@ func LoadMigrations(migrationPath string) (*Migrations, error) {
 			}
 		}
 
+		if !isRollback {
+			directives, err := readDirectives(filePath)
+			if err != nil {
+				return err
+			}
+			data.SingleFile = directives.SingleFile
+			data.DependsOn = directives.DependsOn
+			data.Envs = directives.Envs
+			data.Phase = directives.Phase
+			data.Irreversible = directives.Irreversible
+		}
+		if data.SingleFile && (isRollback || data.DownFilename != "") {
+			return fmt.Errorf("migration %s has both up/down sections and a rollback file", migrationName)
+		}
+
 		if isRollback {
-			data.DownFilename = path.Join(migrationPath, filename)
+			if data.DownFilename != "" {
+				return fmt.Errorf("duplicate migration %s: %s and %s", migrationName, data.DownFilename, filePath)
+			}
+			data.DownFilename = filePath
 		} else {
-			data.UpFilename = path.Join(migrationPath, filename)
+			if data.UpFilename != "" {
+				return fmt.Errorf("duplicate migration %s: %s and %s", migrationName, data.UpFilename, filePath)
+			}
+			data.UpFilename = filePath
 		}
 
 		migrations.infos[migrationName] = data
+		return nil
+	})
+	if err != nil {
+		return nil, withCode(ExitConfig, err)
+	}
+
+	// 按版本号排序，不依赖文件系统返回的顺序
+	sort.SliceStable(migrations.names, func(i, j int) bool {
+		vi, vj := MigrationVersion(migrations.names[i]), MigrationVersion(migrations.names[j])
+		if vi != vj {
+			return vi < vj
+		}
+		return migrations.names[i] < migrations.names[j]
+	})
+
+	err = migrations.sortByDependencies()
+	if err != nil {
+		return nil, withCode(ExitConfig, err)
 	}
 
 	return migrations, nil
 }
+
+// 在版本号顺序的基础上按依赖关系排序：每次取出依赖都已排好的 migration 中版本号最小的一个，
+// 没有声明依赖时顺序不变
+func (m *Migrations) sortByDependencies() error {
+	index := make(map[string]int, len(m.names))
+	for idx, name := range m.names {
+		index[name] = idx
+	}
+	dependents := make(map[string][]string)
+	waiting := make(map[string]int)
+	for _, name := range m.names {
+		for _, dependency := range m.infos[name].DependsOn {
+			if !m.Exists(dependency) {
+				return fmt.Errorf("migration %s depends on %s, which does not exist", name, dependency)
+			}
+			dependents[dependency] = append(dependents[dependency], name)
+			waiting[name]++
+		}
+	}
+
+	// 可以排入的 migration 的下标，保持升序
+	ready := make([]int, 0)
+	for idx, name := range m.names {
+		if waiting[name] == 0 {
+			ready = append(ready, idx)
+		}
+	}
+	sorted := make([]string, 0, len(m.names))
+	for len(ready) > 0 {
+		name := m.names[ready[0]]
+		ready = ready[1:]
+		sorted = append(sorted, name)
+		for _, dependent := range dependents[name] {
+			waiting[dependent]--
+			if waiting[dependent] == 0 {
+				pos := sort.SearchInts(ready, index[dependent])
+				ready = append(ready[:pos], append([]int{index[dependent]}, ready[pos:]...)...)
+			}
+		}
+	}
+
+	if len(sorted) < len(m.names) {
+		return fmt.Errorf("migrations depend on each other: %s", strings.Join(m.findCycle(waiting), " -> "))
+	}
+	m.names = sorted
+	return nil
+}
+
+// 从还有未满足依赖的 migration 出发，沿依赖找到一个环
+func (m *Migrations) findCycle(waiting map[string]int) []string {
+	name := ""
+	for _, candidate := range m.names {
+		if waiting[candidate] > 0 {
+			name = candidate
+			break
+		}
+	}
+	path := make([]string, 0)
+	visited := make(map[string]int)
+	for {
+		if start, exist := visited[name]; exist {
+			return append(path[start:], name)
+		}
+		visited[name] = len(path)
+		path = append(path, name)
+		for _, dependency := range m.infos[name].DependsOn {
+			if waiting[dependency] > 0 {
+				name = dependency
+				break
+			}
+		}
+	}
+}
+
+// 是否有该阶段的 migration
+func (m *Migrations) HasPhase(phase MigrationPhase) bool {
+	for _, info := range m.infos {
+		if info.Phase == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// Laravel 风格的版本号，如 2014_10_12_000000_create_users_table
+var laravelVersion = regexp.MustCompile(`^\d{4}_\d{2}_\d{2}_\d{6}(_|$)`)
+
+// 返回 migration 名称开头的数字部分
+func versionPrefix(name string) string {
+	if laravelVersion.MatchString(name) {
+		return strings.ReplaceAll(name[:17], "_", "")
+	}
+
+	end := 0
+	for end < len(name) && name[end] >= '0' && name[end] <= '9' {
+		end++
+	}
+	return name[:end]
+}
+
+// 解析 migration 的版本号，没有版本号时返回 0。
+// 旧版本生成的 12 位（分钟精度）时间戳会补齐为 14 位，以便和秒精度的时间戳比较
+func MigrationVersion(name string) uint64 {
+	prefix := versionPrefix(name)
+	version, err := strconv.ParseUint(prefix, 10, 64)
+	if err != nil {
+		return 0
+	}
+	if len(prefix) == 12 {
+		version *= 100
+	}
+	return version
+}
+
+// 是否为序号形式的版本号（时间戳至少有 12 位）
+func isSequenceVersion(name string) bool {
+	prefix := versionPrefix(name)
+	return prefix != "" && len(prefix) < 12
+}
+
+// 找出排在最近一次已执行的 migration 之前、但还未执行的 migration，
+// 不适用于环境 env 的 migration 不会执行，不算在内。
+// pre 和 post 阶段分开比较，post 阶段的 migration 落后于之后部署的 pre 阶段是正常的
+func (m *Migrations) GetOutOfOrder(applied map[string]struct{}, env string) []string {
+	lastApplied := make(map[MigrationPhase]int)
+	for idx, name := range m.names {
+		if _, exist := applied[name]; exist {
+			lastApplied[m.infos[name].Phase] = idx
+		}
+	}
+
+	outOfOrder := make([]string, 0)
+	for idx, name := range m.names {
+		last, exist := lastApplied[m.infos[name].Phase]
+		if !exist || idx >= last {
+			continue
+		}
+		if _, exist := applied[name]; !exist && m.infos[name].AppliesTo(env) {
+			outOfOrder = append(outOfOrder, name)
+		}
+	}
+	return outOfOrder
+}
+
+const (
+	DirectiveUp           = "Up"
+	DirectiveDown         = "Down"
+	DirectiveDependsOn    = "depends-on"
+	DirectiveEnv          = "env"
+	DirectivePhase        = "phase"
+	DirectiveIrreversible = "irreversible"
+)
+
+// 零停机部署时 migration 所处的阶段
+type MigrationPhase string
+
+const (
+	PhasePre  MigrationPhase = "pre"  // 部署新代码前执行，只做新增类的变更（默认）
+	PhasePost MigrationPhase = "post" // 部署新代码后执行，清理旧代码还在使用的结构
+)
+
+func (p MigrationPhase) IsValid() bool {
+	switch p {
+	case PhasePre, PhasePost:
+		return true
+	}
+	return false
+}
+
+// 解析形如 `-- +blueprint Up` 的指令行，返回 +blueprint 之后的内容
+func parseDirective(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "--") {
+		return "", false
+	}
+	line = strings.TrimSpace(line[2:])
+	if !strings.HasPrefix(line, "+blueprint ") {
+		return "", false
+	}
+	return strings.TrimSpace(line[len("+blueprint "):]), true
+}
+
+// migration 文件中声明的指令
+type migrationDirectives struct {
+	SingleFile   bool           // 包含 `-- +blueprint Up`
+	DependsOn    []string       // `-- +blueprint depends-on: a, b`，可以写多行
+	Envs         []string       // `-- +blueprint env: dev, test`
+	Phase        MigrationPhase // `-- +blueprint phase: post`，默认为 pre
+	Irreversible bool           // `-- +blueprint irreversible`
+}
+
+func readDirectives(filename string) (migrationDirectives, error) {
+	directives := migrationDirectives{Phase: PhasePre}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return directives, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		directive, ok := parseDirective(line)
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(directive, DirectiveUp) {
+			directives.SingleFile = true
+			continue
+		}
+		if strings.EqualFold(directive, DirectiveIrreversible) {
+			directives.Irreversible = true
+			continue
+		}
+		key, value, found := strings.Cut(directive, ":")
+		if !found {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case DirectiveDependsOn:
+			for _, name := range strings.Split(value, ",") {
+				// 允许带上 .sql 后缀
+				name = strings.TrimSuffix(strings.TrimSpace(name), ".sql")
+				if name != "" {
+					directives.DependsOn = append(directives.DependsOn, name)
+				}
+			}
+		case DirectivePhase:
+			directives.Phase = MigrationPhase(strings.ToLower(strings.TrimSpace(value)))
+			if !directives.Phase.IsValid() {
+				return directives, fmt.Errorf("invalid phase of %s: %s (pre/post)", filename, strings.TrimSpace(value))
+			}
+		case DirectiveEnv:
+			for _, env := range strings.Split(value, ",") {
+				if env = strings.TrimSpace(env); env != "" {
+					directives.Envs = append(directives.Envs, env)
+				}
+			}
+		}
+	}
+	return directives, nil
+}
+
+// 把单文件格式的内容拆分为 up 和 down 两部分，Up 之前的内容会被忽略
+func splitSingleFile(content string) (up, down string) {
+	var upLines, downLines []string
+	var section *[]string
+	for _, line := range strings.Split(content, "\n") {
+		if directive, ok := parseDirective(line); ok {
+			switch {
+			case strings.EqualFold(directive, DirectiveUp):
+				section = &upLines
+				continue
+			case strings.EqualFold(directive, DirectiveDown):
+				section = &downLines
+				continue
+			}
+		}
+		if section != nil {
+			*section = append(*section, line)
+		}
+	}
+	return strings.Join(upLines, "\n"), strings.Join(downLines, "\n")
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testMigration struct {
+	name      string
+	dependsOn []string
+	envs      []string
+	phase     MigrationPhase
+}
+
+// 在 dir 下按相对路径写入文件，自动创建子目录
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(filename), 0755)
+		if err == nil {
+			err = os.WriteFile(filename, []byte(content), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// 按给出的顺序（即版本号顺序）构造 Migrations
+func newTestMigrations(list ...testMigration) *Migrations {
+	migrations := &Migrations{
+		names: make([]string, 0, len(list)),
+		infos: make(map[string]MigrationInfo, len(list)),
+	}
+	for _, m := range list {
+		if m.phase == "" {
+			m.phase = PhasePre
+		}
+		migrations.names = append(migrations.names, m.name)
+		migrations.infos[m.name] = MigrationInfo{Name: m.name, DependsOn: m.dependsOn, Envs: m.envs, Phase: m.phase}
+	}
+	return migrations
+}
+
+func TestSortByDependencies(t *testing.T) {
+	tests := []struct {
+		name       string
+		migrations []testMigration
+		want       []string
+		wantErr    string
+	}{
+		{
+			name:       "no dependencies keep version order",
+			migrations: []testMigration{{name: "1_a"}, {name: "2_b"}, {name: "3_c"}},
+			want:       []string{"1_a", "2_b", "3_c"},
+		},
+		{
+			name:       "dependency earlier in version order",
+			migrations: []testMigration{{name: "1_a"}, {name: "2_b", dependsOn: []string{"1_a"}}, {name: "3_c"}},
+			want:       []string{"1_a", "2_b", "3_c"},
+		},
+		{
+			name:       "dependency later in version order",
+			migrations: []testMigration{{name: "1_a", dependsOn: []string{"3_c"}}, {name: "2_b"}, {name: "3_c"}},
+			want:       []string{"2_b", "3_c", "1_a"},
+		},
+		{
+			name: "chain of later dependencies",
+			migrations: []testMigration{
+				{name: "1_a", dependsOn: []string{"2_b"}}, {name: "2_b", dependsOn: []string{"3_c"}}, {name: "3_c"}, {name: "4_d"},
+			},
+			want: []string{"3_c", "2_b", "1_a", "4_d"},
+		},
+		{
+			name: "diamond",
+			migrations: []testMigration{
+				{name: "1_base"},
+				{name: "2_left", dependsOn: []string{"1_base"}},
+				{name: "3_right", dependsOn: []string{"1_base"}},
+				{name: "4_top", dependsOn: []string{"3_right", "2_left"}},
+			},
+			want: []string{"1_base", "2_left", "3_right", "4_top"},
+		},
+		{
+			name: "independent migrations stay in version order",
+			migrations: []testMigration{
+				{name: "1_a"}, {name: "2_b", dependsOn: []string{"5_e"}}, {name: "3_c"}, {name: "4_d"}, {name: "5_e"}, {name: "6_f"},
+			},
+			want: []string{"1_a", "3_c", "4_d", "5_e", "2_b", "6_f"},
+		},
+		{
+			name:       "missing dependency",
+			migrations: []testMigration{{name: "1_a"}, {name: "2_b", dependsOn: []string{"9_gone"}}},
+			wantErr:    "migration 2_b depends on 9_gone, which does not exist",
+		},
+		{
+			name:       "cycle of two",
+			migrations: []testMigration{{name: "1_a", dependsOn: []string{"2_b"}}, {name: "2_b", dependsOn: []string{"1_a"}}, {name: "3_c"}},
+			wantErr:    "migrations depend on each other: 1_a -> 2_b -> 1_a",
+		},
+		{
+			name: "cycle reached through a waiting migration",
+			migrations: []testMigration{
+				{name: "1_x", dependsOn: []string{"2_a"}},
+				{name: "2_a", dependsOn: []string{"3_b"}},
+				{name: "3_b", dependsOn: []string{"4_c"}},
+				{name: "4_c", dependsOn: []string{"2_a"}},
+			},
+			wantErr: "migrations depend on each other: 2_a -> 3_b -> 4_c -> 2_a",
+		},
+		{
+			name:       "depends on itself",
+			migrations: []testMigration{{name: "1_a", dependsOn: []string{"1_a"}}},
+			wantErr:    "migrations depend on each other: 1_a -> 1_a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations := newTestMigrations(tt.migrations...)
+			err := migrations.sortByDependencies()
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got := migrations.GetNames(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadMigrationsDependsOn(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"000001_create_users.sql":         "CREATE TABLE users (id INT);",
+		"000002_add_posts_fk.sql":         "-- +blueprint depends-on: 000003_create_posts.sql\n-- +blueprint depends-on: 000001_create_users\nALTER TABLE posts ADD user_id INT;",
+		"feature/000003_create_posts.sql": "CREATE TABLE posts (id INT);",
+		"000004_create_tags.sql":          "CREATE TABLE tags (id INT);",
+	})
+
+	migrations, err := LoadMigrations(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"000001_create_users", "000003_create_posts", "000002_add_posts_fk", "000004_create_tags"}
+	if got := migrations.GetNames(); !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+	wantDeps := []string{"000003_create_posts", "000001_create_users"}
+	if got := migrations.GetInfo("000002_add_posts_fk").DependsOn; !reflect.DeepEqual(got, wantDeps) {
+		t.Errorf("depends on = %v, want %v", got, wantDeps)
+	}
+
+	// 依赖不存在时是配置错误
+	writeTestFiles(t, dir, map[string]string{"000005_broken.sql": "-- +blueprint depends-on: 000009_gone\n"})
+	_, err = LoadMigrations(dir, nil)
+	if exitCode(err) != ExitConfig || !strings.Contains(err.Error(), "000009_gone") {
+		t.Errorf("err = %v (exit code %d), want a config error naming 000009_gone", err, exitCode(err))
+	}
+}
+
+func TestCheckDependents(t *testing.T) {
+	migrations := newTestMigrations(
+		testMigration{name: "1_a"},
+		testMigration{name: "2_b", dependsOn: []string{"1_a"}},
+		testMigration{name: "3_c", dependsOn: []string{"2_b"}},
+		testMigration{name: "4_d"},
+	)
+	db := &DBConnection{Config: DBConfig{Type: SQLite, File: "./app.db"}}
+	records := func(names ...string) []MigrationRec {
+		recs := make([]MigrationRec, 0, len(names))
+		for idx, name := range names {
+			recs = append(recs, MigrationRec{Id: uint(idx + 1), Migration: name, Batch: 1})
+		}
+		return recs
+	}
+
+	tests := []struct {
+		name      string
+		applied   []string
+		rollback  []string
+		wantLines []string
+	}{
+		{"nothing depends on it", []string{"1_a", "2_b", "3_c", "4_d"}, []string{"4_d"}, nil},
+		{"dependent rolled back with it", []string{"1_a", "2_b", "3_c", "4_d"}, []string{"4_d", "3_c", "2_b"}, nil},
+		{"dependent not applied", []string{"1_a", "2_b", "4_d"}, []string{"2_b"}, nil},
+		{"applied dependent", []string{"1_a", "2_b", "3_c", "4_d"}, []string{"2_b"}, []string{"3_c is still applied and depends on 2_b"}},
+		{
+			"only the direct dependent of a chain", []string{"1_a", "2_b", "3_c"}, []string{"1_a"},
+			[]string{"2_b is still applied and depends on 1_a"},
+		},
+		{"applied record without file", []string{"1_a", "0_removed"}, []string{"1_a"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDependents(db, migrations, records(tt.applied...), records(tt.rollback...))
+			if len(tt.wantLines) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if exitCode(err) != ExitRefused {
+				t.Fatalf("err = %v (exit code %d), want a refusal", err, exitCode(err))
+			}
+			for _, line := range tt.wantLines {
+				if !strings.Contains(err.Error(), line) {
+					t.Errorf("error %q does not mention %q", err, line)
+				}
+			}
+		})
+	}
+}
+
+func TestGetOutOfOrder(t *testing.T) {
+	migrations := newTestMigrations(
+		testMigration{name: "1_a"},
+		testMigration{name: "2_seed_dev", envs: []string{"dev"}},
+		testMigration{name: "3_drop_column", phase: PhasePost},
+		testMigration{name: "4_b"},
+		testMigration{name: "5_c"},
+	)
+	applied := func(names ...string) map[string]struct{} {
+		m := make(map[string]struct{}, len(names))
+		for _, name := range names {
+			m[name] = struct{}{}
+		}
+		return m
+	}
+
+	tests := []struct {
+		name    string
+		applied map[string]struct{}
+		env     string
+		want    []string
+	}{
+		{"nothing applied", applied(), "dev", []string{}},
+		{"applied in order", applied("1_a", "2_seed_dev", "4_b"), "dev", []string{}},
+		{"gap before the latest", applied("1_a", "4_b"), "dev", []string{"2_seed_dev"}},
+		{"gap of another env", applied("1_a", "4_b"), "prod", []string{}},
+		{"pending before the latest", applied("5_c"), "prod", []string{"1_a", "4_b"}},
+		{"post migration behind pre ones", applied("1_a", "4_b", "5_c"), "prod", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := migrations.GetOutOfOrder(tt.applied, tt.env); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetOutOfOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrationVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  uint64
+		sequence bool
+	}{
+		{"20241118165301_create_users", 20241118165301, false},
+		{"202411181653_create_users", 20241118165300, false},
+		{"2014_10_12_000000_create_users_table", 20141012000000, false},
+		{"2014_10_12_000000", 20141012000000, false},
+		{"000042_add_index", 42, true},
+		{"7_seed", 7, true},
+		{"create_users", 0, false},
+		{"2014_10_12_create_users", 2014, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MigrationVersion(tt.name); got != tt.version {
+				t.Errorf("MigrationVersion() = %d, want %d", got, tt.version)
+			}
+			if got := isSequenceVersion(tt.name); got != tt.sequence {
+				t.Errorf("isSequenceVersion() = %v, want %v", got, tt.sequence)
+			}
+		})
+	}
+}
+
+func TestLoadMigrationsVersionOrder(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"20241118165301_second.sql":                "",
+		"202411181653_minute_precision.sql":        "",
+		"20241118165259_first.sql":                 "",
+		"2014_10_12_000000_create_users_table.sql": "",
+		"b/20241118165301_another_second.sql":      "",
+	})
+
+	migrations, err := LoadMigrations(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"2014_10_12_000000_create_users_table",
+		"20241118165259_first",
+		"202411181653_minute_precision",
+		"20241118165301_another_second",
+		"20241118165301_second",
+	}
+	if got := migrations.GetNames(); !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+}
+
+func TestSplitSingleFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		wantUp   string
+		wantDown string
+	}{
+		{
+			"up and down",
+			"-- +blueprint Up\nCREATE TABLE users (id INT);\n-- +blueprint Down\nDROP TABLE users;",
+			"CREATE TABLE users (id INT);", "DROP TABLE users;",
+		},
+		{
+			"header before up is ignored",
+			"-- users table\n-- +blueprint depends-on: 000001_a\n-- +blueprint Up\nCREATE TABLE users (id INT);\n",
+			"CREATE TABLE users (id INT);\n", "",
+		},
+		{
+			"case and spacing of directives",
+			"--  +blueprint up\nA;\n--+blueprint DOWN\nB;",
+			"A;", "B;",
+		},
+		{
+			"down before up",
+			"-- +blueprint Down\nB;\n-- +blueprint Up\nA;",
+			"A;", "B;",
+		},
+		{
+			"other directives stay in the section",
+			"-- +blueprint Up\nA;\n-- +blueprint irreversible\n",
+			"A;\n-- +blueprint irreversible\n", "",
+		},
+		{"no sections", "CREATE TABLE users (id INT);", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up, down := splitSingleFile(tt.content)
+			if up != tt.wantUp || down != tt.wantDown {
+				t.Errorf("splitSingleFile() = %q, %q, want %q, %q", up, down, tt.wantUp, tt.wantDown)
+			}
+		})
+	}
+}
+
+func TestReadDirectives(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    migrationDirectives
+		wantErr bool
+	}{
+		{"no directives", "CREATE TABLE users (id INT);", migrationDirectives{Phase: PhasePre}, false},
+		{
+			"all directives",
+			"-- +blueprint Up\n-- +blueprint depends-on: 000001_a.sql, 000002_b\n-- +blueprint depends-on: 000003_c\n" +
+				"-- +blueprint env: dev, test\n-- +blueprint phase: POST\n-- +blueprint irreversible\nSELECT 1;",
+			migrationDirectives{
+				SingleFile: true, DependsOn: []string{"000001_a", "000002_b", "000003_c"},
+				Envs: []string{"dev", "test"}, Phase: PhasePost, Irreversible: true,
+			},
+			false,
+		},
+		{"not a blueprint comment", "-- +goose Up\n-- blueprint irreversible\nSELECT 1;", migrationDirectives{Phase: PhasePre}, false},
+		{"invalid phase", "-- +blueprint phase: later", migrationDirectives{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "000009_x.sql")
+			writeTestFiles(t, filepath.Dir(filename), map[string]string{filepath.Base(filename): tt.content})
+			got, err := readDirectives(filename)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readDirectives() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSingleFileMigration(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"000001_create_users.sql": "-- +blueprint Up\nCREATE TABLE users (id INT);\n-- +blueprint Down\nDROP TABLE users;\n",
+	})
+	migrations, err := LoadMigrations(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migration := migrations.GetInfo("000001_create_users")
+	if err := migration.LoadSQLFile(); err != nil {
+		t.Fatal(err)
+	}
+	if !migration.SingleFile || migration.GetUpSQL() != "CREATE TABLE users (id INT);" || migration.GetDownSQL() != "DROP TABLE users;\n" {
+		t.Errorf("up = %q, down = %q", migration.GetUpSQL(), migration.GetDownSQL())
+	}
+
+	// 单文件格式的 migration 不能再有回滚文件
+	writeTestFiles(t, dir, map[string]string{"000001_create_users_rollback.sql": "DROP TABLE users;"})
+	_, err = LoadMigrations(dir, nil)
+	if exitCode(err) != ExitConfig || !strings.Contains(err.Error(), "both up/down sections and a rollback file") {
+		t.Errorf("err = %v (exit code %d), want a config error", err, exitCode(err))
+	}
+}
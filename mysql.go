@@ -13,15 +13,16 @@ type MySQLDriver struct{}
 // 连接到数据库
 func (d MySQLDriver) Connect(host string, port uint, user, pass, dbName string) (*sql.DB, error) {
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?timeout=5s", user, pass, host, port, dbName)
+	safeDSN := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?timeout=5s", user, redacted, host, port, dbName)
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
-		return nil, err
+		return nil, redactDSN(err, dsn, safeDSN)
 	}
 
 	err = db.Ping()
 	if err != nil {
 		db.Close()
-		return nil, err
+		return nil, redactDSN(err, dsn, safeDSN)
 	}
 
 	return db, nil
@@ -41,22 +42,49 @@ func (d MySQLDriver) CheckMigrationInfoTable(db *sql.DB) error {
 			  id int unsigned NOT NULL AUTO_INCREMENT,
 			  migration varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
 			  batch int unsigned NOT NULL,
+			  down_sql longtext CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NULL,
 			  PRIMARY KEY (id)
 			) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 		`)
 		if err != nil {
 			return err
 		}
+		return nil
+	}
+
+	// 旧版本创建的表没有 down_sql 字段，补上
+	exists, err := d.hasColumn(db, "migrations", "down_sql")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		_, err := db.Exec("ALTER TABLE migrations ADD COLUMN down_sql longtext CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NULL")
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// 检查字段是否存在
+func (d MySQLDriver) hasColumn(db *sql.DB, table, column string) (bool, error) {
+	var count int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?",
+		table, column,
+	).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
 // 获取过往 migration 记录
 func (d MySQLDriver) GetMigrationInfos(db *sql.DB) ([]MigrationRec, error) {
 	info := make([]MigrationRec, 0)
 
-	rows, err := db.Query("SELECT id, migration, batch FROM migrations")
+	rows, err := db.Query("SELECT id, migration, batch, down_sql FROM migrations")
 	if err != nil {
 		return nil, err
 	}
@@ -64,7 +92,7 @@ func (d MySQLDriver) GetMigrationInfos(db *sql.DB) ([]MigrationRec, error) {
 
 	for rows.Next() {
 		rec := MigrationRec{}
-		err = rows.Scan(&rec.Id, &rec.Migration, &rec.Batch)
+		err = rows.Scan(&rec.Id, &rec.Migration, &rec.Batch, &rec.DownSQL)
 		if err != nil {
 			return nil, err
 		}
@@ -77,9 +105,9 @@ func (d MySQLDriver) GetMigrationInfos(db *sql.DB) ([]MigrationRec, error) {
 // 插入 migration 记录
 func (d MySQLDriver) InsertMigrationInfo(db *sql.Tx, info MigrationRec) error {
 	_, err := db.Exec(`
-		INSERT INTO migrations (migration, batch)
-		VALUES (?, ?);
-	`, info.Migration, info.Batch)
+		INSERT INTO migrations (migration, batch, down_sql)
+		VALUES (?, ?, ?);
+	`, info.Migration, info.Batch, info.DownSQL)
 	if err != nil {
 		return err
 	}
@@ -93,27 +121,20 @@ func (d MySQLDriver) DeleteMigrationInfo(db *sql.Tx, id uint) error {
 	return err
 }
 
-// 执行迁移
-func (d MySQLDriver) ExecMigration(db *sql.Tx, migrationSQL string) error {
+// 分割 migration 中的多条语句
+func (d MySQLDriver) SplitStatements(migrationSQL string) []string {
 	// 分割多条语句
-	statements := strings.Split(migrationSQL, ";")
-
-	// 逐个执行每条语句
-	for _, statement := range statements {
+	statements := make([]string, 0)
+	for _, statement := range strings.Split(migrationSQL, ";") {
 		statement = strings.TrimSpace(statement)
-		if statement == "" {
-			// 跳过空语句
+		if IsEmptySQL(statement) {
+			// 跳过空语句和只有注释的语句，例如放在最后一条语句之后的指令
 			continue
 		}
-
-		// 执行语句
-		_, err := db.Exec(statement)
-		if err != nil {
-			return err
-		}
+		statements = append(statements, statement)
 	}
 
-	return nil
+	return statements
 }
 
 // 指定表结构
@@ -132,6 +153,92 @@ func (d MySQLDriver) ShowTableCreate(db *sql.DB, table string) (string, error) {
 	return creation, nil
 }
 
+// 检查审计表，不存在则创建
+func (d MySQLDriver) CheckAuditTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS ` + AuditTableName + ` (
+		  id bigint unsigned NOT NULL AUTO_INCREMENT,
+		  created_at varchar(64) NOT NULL,
+		  os_user varchar(255) NOT NULL,
+		  host varchar(255) NOT NULL,
+		  env varchar(64) NOT NULL,
+		  command varchar(64) NOT NULL,
+		  outcome varchar(16) NOT NULL,
+		  record longtext CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+		  PRIMARY KEY (id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+	`)
+	return err
+}
+
+// 插入审计记录
+func (d MySQLDriver) InsertAuditRecord(db *sql.DB, record AuditRecord, content string) error {
+	_, err := db.Exec(`
+		INSERT INTO `+AuditTableName+` (created_at, os_user, host, env, command, outcome, record)
+		VALUES (?, ?, ?, ?, ?, ?, ?);
+	`, record.Time, record.User, record.Host, record.Env, record.Command, record.Outcome, content)
+	return err
+}
+
+func (d MySQLDriver) Placeholder(n int) string {
+	return "?"
+}
+
+func (d MySQLDriver) QuoteIdentifier(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = "`" + strings.ReplaceAll(part, "`", "``") + "`"
+	}
+	return strings.Join(parts, ".")
+}
+
+// 检查 seed 记录表，不存在则创建
+func (d MySQLDriver) CheckSeedTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS ` + SeedTableName + ` (
+		  id bigint unsigned NOT NULL AUTO_INCREMENT,
+		  seed varchar(255) NOT NULL,
+		  checksum varchar(64) NOT NULL,
+		  env varchar(64) NOT NULL,
+		  seeded_at varchar(64) NOT NULL,
+		  PRIMARY KEY (id),
+		  UNIQUE KEY seed (seed)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+	`)
+	return err
+}
+
+func (d MySQLDriver) GetSeedRecords(db *sql.DB) ([]SeedRec, error) {
+	recs := make([]SeedRec, 0)
+	rows, err := db.Query("SELECT seed, checksum, env, seeded_at FROM " + SeedTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rec := SeedRec{}
+		err = rows.Scan(&rec.Seed, &rec.Checksum, &rec.Env, &rec.SeededAt)
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+	return recs, rows.Err()
+}
+
+// 保存 seed 的执行记录，替换之前的记录
+func (d MySQLDriver) SaveSeedRecord(db *sql.Tx, rec SeedRec) error {
+	_, err := db.Exec(`DELETE FROM `+SeedTableName+` WHERE seed = ?`, rec.Seed)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		INSERT INTO `+SeedTableName+` (seed, checksum, env, seeded_at)
+		VALUES (?, ?, ?, ?);
+	`, rec.Seed, rec.Checksum, rec.Env, rec.SeededAt)
+	return err
+}
+
 func (d MySQLDriver) GetTables(db *sql.DB) ([]string, error) {
 	tables := make([]string, 0)
 	rows, err := db.Query("SHOW TABLES")
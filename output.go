@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type OutputFormat string
+
+const (
+	OutputText OutputFormat = "text"
+	OutputJSON OutputFormat = "json"
+)
+
+func (f OutputFormat) IsValid() bool {
+	switch f {
+	case OutputText, OutputJSON:
+		return true
+	}
+	return false
+}
+
+var outputFormat = OutputText
+
+// CommandResult 中 migration 的状态
+const (
+	StatusApplied       = "applied"        // 本次执行 / 已执行（status）
+	StatusSkipped       = "skipped"        // 本次未执行
+	StatusPending       = "pending"        // 尚未执行
+	StatusRolledBack    = "rolled_back"    // 本次回滚
+	StatusPruned        = "pruned"         // 只删除记录，不执行 SQL
+	StatusImported      = "imported"       // 从其他工具的执行历史导入
+	StatusNotApplicable = "not_applicable" // 只在其他环境执行
+	StatusFailed        = "failed"
+)
+
+// 命令的结构化结果，--output json 时输出为一个 JSON
+type CommandResult struct {
+	Command   string            `json:"command"`
+	Success   bool              `json:"success"`
+	ExitCode  ExitCode          `json:"exit_code"`
+	DryRun    bool              `json:"dry_run,omitempty"`
+	Databases []*DatabaseResult `json:"databases,omitempty"`
+	Files     []string          `json:"files,omitempty"`
+	Warnings  []string          `json:"warnings,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+type DatabaseResult struct {
+	Database   string             `json:"database"`
+	Type       DBType             `json:"type"`
+	Batch      uint               `json:"batch,omitempty"`
+	Migrations []*MigrationResult `json:"migrations"`
+	Error      string             `json:"error,omitempty"`
+
+	conn     *DBConnection
+	started  time.Time
+	span     *Span
+	notified bool // 已经通知过 webhook
+}
+
+type MigrationResult struct {
+	Name       string             `json:"name"`
+	Batch      uint               `json:"batch,omitempty"`
+	Status     string             `json:"status"`
+	Notes      []string           `json:"notes,omitempty"`    // 如 out_of_order、file_missing
+	Checksum   string             `json:"checksum,omitempty"` // 执行的 SQL 的 sha256
+	DurationMs float64            `json:"duration_ms,omitempty"`
+	Statements []*StatementResult `json:"statements,omitempty"`
+	Error      string             `json:"error,omitempty"`
+
+	Duration time.Duration `json:"-"`
+}
+
+// 事务回滚后 migration 没有记录到 migrations 表，
+// 但 DDL 会隐式提交的数据库（如 MySQL）可能已经执行了
+func (d *DatabaseResult) MarkNotRecorded() {
+	for _, m := range d.Migrations {
+		switch m.Status {
+		case StatusApplied, StatusRolledBack, StatusPruned:
+			m.Notes = append(m.Notes, "not_recorded")
+		}
+	}
+}
+
+// 记录 migration 执行的语句和耗时
+func (m *MigrationResult) SetStatements(statements []*StatementResult, duration time.Duration) {
+	m.Statements = statements
+	m.Duration = duration
+	m.DurationMs = durationMs(duration)
+}
+
+// DBConnection.ExecMigration 执行的一条语句
+type StatementResult struct {
+	SQL          string  `json:"sql"`
+	DurationMs   float64 `json:"duration_ms"`
+	RowsAffected int64   `json:"rows_affected"`
+	Error        string  `json:"error,omitempty"`
+
+	Duration time.Duration `json:"-"`
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// 取整后输出耗时
+func formatDuration(d time.Duration) string {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond).String()
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+var result = &CommandResult{}
+
+// 开始记录下一个数据库的结果，数据库是逐个处理的，上一个的 span 在这里结束
+func (r *CommandResult) AddDatabase(db *DBConnection) *DatabaseResult {
+	if len(r.Databases) > 0 {
+		r.Databases[len(r.Databases)-1].span.End(nil)
+	}
+	dbResult := &DatabaseResult{
+		Database:   db.Config.Label(),
+		Type:       db.Config.Type,
+		Migrations: make([]*MigrationResult, 0),
+		conn:       db,
+		started:    time.Now(),
+		span: tracer.StartSpan(nil, "db "+db.Config.Label(), spanKindInternal,
+			"db.system", dbSystem(db.Config.Type), "db.name", db.Config.Name+db.Config.File, "blueprint.database", db.Config.Label()),
+	}
+	r.Databases = append(r.Databases, dbResult)
+	return dbResult
+}
+
+// 命令在该数据库上的错误，没有记录到任何数据库的错误发生在最后处理的那个上
+func (r *CommandResult) DatabaseError(d *DatabaseResult, cmdErr error) string {
+	if d.Error != "" {
+		return logger.Redact(d.Error)
+	}
+	if cmdErr != nil && len(r.Databases) > 0 && d == r.Databases[len(r.Databases)-1] {
+		return logger.Redact(cmdErr.Error())
+	}
+	return ""
+}
+
+func (r *CommandResult) AddFile(filename string) {
+	r.Files = append(r.Files, filename)
+}
+
+func (d *DatabaseResult) AddMigration(name string, batch uint, status string, notes ...string) *MigrationResult {
+	migrationResult := &MigrationResult{
+		Name:   name,
+		Batch:  batch,
+		Status: status,
+		Notes:  notes,
+	}
+	d.Migrations = append(d.Migrations, migrationResult)
+	return migrationResult
+}
+
+// 输出 status 表格等命令结果，json 模式下不输出；进度信息走 logger
+func echof(format string, a ...any) {
+	if outputFormat == OutputJSON {
+		return
+	}
+	fmt.Printf(format, a...)
+}
+
+// 输出警告并记录到命令结果中
+func warnf(format string, a ...any) {
+	msg := logger.Redact(fmt.Sprintf(format, a...))
+	result.Warnings = append(result.Warnings, msg)
+	logger.Warn(msg)
+}
+
+// json 模式下输出命令结果
+func printResult(err error) {
+	if outputFormat != OutputJSON {
+		return
+	}
+
+	result.Success = err == nil
+	result.ExitCode = exitCode(err)
+	if err != nil {
+		result.Error = logger.Redact(err.Error())
+	}
+	output, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Fprintln(os.Stdout, string(output))
+}
+
+// 输出数据库上最慢的语句和 migration
+func logTimingSummary(db *DBConnection, dbResult *DatabaseResult) {
+	const top = 5
+
+	type statementTiming struct {
+		migration string
+		statement *StatementResult
+	}
+	statements := make([]statementTiming, 0)
+	migrations := make([]*MigrationResult, 0)
+	for _, m := range dbResult.Migrations {
+		if len(m.Statements) == 0 {
+			continue
+		}
+		migrations = append(migrations, m)
+		for _, statement := range m.Statements {
+			statements = append(statements, statementTiming{migration: m.Name, statement: statement})
+		}
+	}
+	if len(statements) == 0 {
+		return
+	}
+
+	sort.SliceStable(statements, func(i, j int) bool {
+		return statements[i].statement.Duration > statements[j].statement.Duration
+	})
+	sort.SliceStable(migrations, func(i, j int) bool {
+		return migrations[i].Duration > migrations[j].Duration
+	})
+
+	logger.Info(fmt.Sprintf("Slowest statements of db[%s]:", db.Config.Label()))
+	for i := 0; i < len(statements) && i < top; i++ {
+		logger.Info(fmt.Sprintf("  %-10s %s  %s",
+			formatDuration(statements[i].statement.Duration), statements[i].migration, statementPreview(statements[i].statement.SQL)))
+	}
+	logger.Info(fmt.Sprintf("Slowest migrations of db[%s]:", db.Config.Label()))
+	for i := 0; i < len(migrations) && i < top; i++ {
+		logger.Info(fmt.Sprintf("  %-10s %s (%d statement(s))",
+			formatDuration(migrations[i].Duration), migrations[i].Name, len(migrations[i].Statements)))
+	}
+}
+
+// 把语句压成一行并截断，用于输出
+func statementPreview(statement string) string {
+	const maxLen = 80
+
+	preview := strings.Join(strings.Fields(statement), " ")
+	// 按字符而不是字节截断，避免截断多字节字符
+	if runes := []rune(preview); len(runes) > maxLen {
+		preview = string(runes[:maxLen-3]) + "..."
+	}
+	return preview
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"net/url"
 	"strings"
 
 	_ "github.com/lib/pq"
@@ -14,16 +15,26 @@ type PostgreSQLDriver struct{}
 func (d PostgreSQLDriver) Connect(host string, port uint, user, pass, dbName string) (*sql.DB, error) {
 	// sslmode=disable is used for simplicity in development environments.
 	// connect_timeout is set to 5 seconds to avoid long waits on unreachable servers.
-	dsn := fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable&connect_timeout=5", user, pass, host, port, dbName)
+	// Built with net/url so that special characters in the password are escaped
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(user, pass),
+		Host:     fmt.Sprintf("%s:%d", host, port),
+		Path:     "/" + dbName,
+		RawQuery: "sslmode=disable&connect_timeout=5",
+	}
+	dsn := u.String()
+	u.User = url.UserPassword(user, redacted)
+	safeDSN := u.String()
 	db, err := sql.Open("postgres", dsn)
 	if err != nil {
-		return nil, err
+		return nil, redactDSN(err, dsn, safeDSN)
 	}
 
 	err = db.Ping()
 	if err != nil {
 		db.Close()
-		return nil, err
+		return nil, redactDSN(err, dsn, safeDSN)
 	}
 
 	return db, nil
@@ -43,21 +54,45 @@ func (d PostgreSQLDriver) CheckMigrationInfoTable(db *sql.DB) error {
 			CREATE TABLE migrations (
 			  id SERIAL PRIMARY KEY,
 			  migration VARCHAR(255) NOT NULL,
-			  batch INTEGER NOT NULL
+			  batch INTEGER NOT NULL,
+			  down_sql TEXT NULL
 			);
 		`)
 		if err != nil {
 			return err
 		}
+		return nil
+	}
+
+	// Tables created by older versions have no down_sql column
+	exists, err = d.hasColumn(db, "migrations", "down_sql")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		_, err := db.Exec("ALTER TABLE migrations ADD COLUMN down_sql TEXT NULL")
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+func (d PostgreSQLDriver) hasColumn(db *sql.DB, table, column string) (bool, error) {
+	var exists bool
+	query := "SELECT EXISTS (SELECT FROM information_schema.columns WHERE table_schema = 'public' AND table_name = $1 AND column_name = $2)"
+	err := db.QueryRow(query, table, column).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
 func (d PostgreSQLDriver) GetMigrationInfos(db *sql.DB) ([]MigrationRec, error) {
 	info := make([]MigrationRec, 0)
 
-	rows, err := db.Query("SELECT id, migration, batch FROM migrations")
+	rows, err := db.Query("SELECT id, migration, batch, down_sql FROM migrations")
 	if err != nil {
 		return nil, err
 	}
@@ -65,7 +100,7 @@ func (d PostgreSQLDriver) GetMigrationInfos(db *sql.DB) ([]MigrationRec, error)
 
 	for rows.Next() {
 		rec := MigrationRec{}
-		err = rows.Scan(&rec.Id, &rec.Migration, &rec.Batch)
+		err = rows.Scan(&rec.Id, &rec.Migration, &rec.Batch, &rec.DownSQL)
 		if err != nil {
 			return nil, err
 		}
@@ -77,9 +112,9 @@ func (d PostgreSQLDriver) GetMigrationInfos(db *sql.DB) ([]MigrationRec, error)
 
 func (d PostgreSQLDriver) InsertMigrationInfo(db *sql.Tx, info MigrationRec) error {
 	_, err := db.Exec(`
-		INSERT INTO migrations (migration, batch)
-		VALUES ($1, $2);
-	`, info.Migration, info.Batch)
+		INSERT INTO migrations (migration, batch, down_sql)
+		VALUES ($1, $2, $3);
+	`, info.Migration, info.Batch, info.DownSQL)
 	if err != nil {
 		return err
 	}
@@ -92,24 +127,20 @@ func (d PostgreSQLDriver) DeleteMigrationInfo(db *sql.Tx, id uint) error {
 	return err
 }
 
-func (d PostgreSQLDriver) ExecMigration(db *sql.Tx, migrationSQL string) error {
+func (d PostgreSQLDriver) SplitStatements(migrationSQL string) []string {
 	// Basic split by semicolon.
 	// Note: This might be fragile for complex PG statements involving $$ quoting.
-	statements := strings.Split(migrationSQL, ";")
-
-	for _, statement := range statements {
+	statements := make([]string, 0)
+	for _, statement := range strings.Split(migrationSQL, ";") {
 		statement = strings.TrimSpace(statement)
-		if statement == "" {
+		// Skip comment-only statements, e.g. a directive after the last statement
+		if IsEmptySQL(statement) {
 			continue
 		}
-
-		_, err := db.Exec(statement)
-		if err != nil {
-			return err
-		}
+		statements = append(statements, statement)
 	}
 
-	return nil
+	return statements
 }
 
 func (d PostgreSQLDriver) ShowTableCreate(db *sql.DB, table string) (string, error) {
@@ -120,6 +151,86 @@ func (d PostgreSQLDriver) ShowTableCreate(db *sql.DB, table string) (string, err
 		errors.New("Dump table is not supported for PostgreSQL in this tool yet, please use pg_dump instead")
 }
 
+func (d PostgreSQLDriver) CheckAuditTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS ` + AuditTableName + ` (
+		  id BIGSERIAL PRIMARY KEY,
+		  created_at VARCHAR(64) NOT NULL,
+		  os_user VARCHAR(255) NOT NULL,
+		  host VARCHAR(255) NOT NULL,
+		  env VARCHAR(64) NOT NULL,
+		  command VARCHAR(64) NOT NULL,
+		  outcome VARCHAR(16) NOT NULL,
+		  record TEXT NOT NULL
+		);
+	`)
+	return err
+}
+
+func (d PostgreSQLDriver) InsertAuditRecord(db *sql.DB, record AuditRecord, content string) error {
+	_, err := db.Exec(`
+		INSERT INTO `+AuditTableName+` (created_at, os_user, host, env, command, outcome, record)
+		VALUES ($1, $2, $3, $4, $5, $6, $7);
+	`, record.Time, record.User, record.Host, record.Env, record.Command, record.Outcome, content)
+	return err
+}
+
+func (d PostgreSQLDriver) Placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+func (d PostgreSQLDriver) QuoteIdentifier(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = `"` + strings.ReplaceAll(part, `"`, `""`) + `"`
+	}
+	return strings.Join(parts, ".")
+}
+
+func (d PostgreSQLDriver) CheckSeedTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS ` + SeedTableName + ` (
+		  id BIGSERIAL PRIMARY KEY,
+		  seed VARCHAR(255) NOT NULL UNIQUE,
+		  checksum VARCHAR(64) NOT NULL,
+		  env VARCHAR(64) NOT NULL,
+		  seeded_at VARCHAR(64) NOT NULL
+		);
+	`)
+	return err
+}
+
+func (d PostgreSQLDriver) GetSeedRecords(db *sql.DB) ([]SeedRec, error) {
+	recs := make([]SeedRec, 0)
+	rows, err := db.Query("SELECT seed, checksum, env, seeded_at FROM " + SeedTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rec := SeedRec{}
+		err = rows.Scan(&rec.Seed, &rec.Checksum, &rec.Env, &rec.SeededAt)
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+	return recs, rows.Err()
+}
+
+// SaveSeedRecord replaces the previous record of the seed
+func (d PostgreSQLDriver) SaveSeedRecord(db *sql.Tx, rec SeedRec) error {
+	_, err := db.Exec(`DELETE FROM `+SeedTableName+` WHERE seed = $1`, rec.Seed)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		INSERT INTO `+SeedTableName+` (seed, checksum, env, seeded_at)
+		VALUES ($1, $2, $3, $4);
+	`, rec.Seed, rec.Checksum, rec.Env, rec.SeededAt)
+	return err
+}
+
 func (d PostgreSQLDriver) GetTables(db *sql.DB) ([]string, error) {
 	tables := make([]string, 0)
 	rows, err := db.Query("SELECT table_name FROM information_schema.tables WHERE table_schema = 'public' AND table_type = 'BASE TABLE'")
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 指定 --report 时这些命令执行后生成报告
+var reportCommands = map[string]bool{
+	"run":      true,
+	"rollback": true,
+}
+
+var reportFile string // --report，.md 或 .html
+
+// 检查 --report 文件的扩展名
+func checkReportFile(filename string) error {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".md", ".markdown", ".html", ".htm":
+		return nil
+	}
+	return errors.New("--report must be a .md or .html file: " + filename)
+}
+
+// 两种格式的报告共用的数据
+type reportData struct {
+	Title     string
+	Command   string
+	Time      string
+	Env       string
+	Success   bool
+	Duration  string
+	Error     string
+	Warnings  []string
+	Databases []reportDatabase
+}
+
+type reportDatabase struct {
+	Database      string
+	Batch         uint
+	Error         string
+	Skipped       int // 已执行过的 migration，只计数
+	NotApplicable int // 只在其他环境执行的 migration，只计数
+	Migrations    []reportMigration
+}
+
+type reportMigration struct {
+	Name       string
+	Batch      uint
+	Status     string
+	Notes      string
+	Duration   string
+	Error      string
+	Statements []reportStatement
+}
+
+type reportStatement struct {
+	SQL          string
+	Duration     string
+	RowsAffected int64
+	Error        string
+}
+
+// 写入命令的执行报告，命令失败时也会生成
+func writeReport(cmdErr error) {
+	if reportFile == "" || !reportCommands[result.Command] {
+		return
+	}
+
+	data := buildReport(cmdErr)
+	var content []byte
+	var err error
+	switch strings.ToLower(filepath.Ext(reportFile)) {
+	case ".html", ".htm":
+		content, err = renderHTMLReport(data)
+	default:
+		content = renderMarkdownReport(data)
+	}
+	if err == nil {
+		err = os.WriteFile(reportFile, content, 0644)
+	}
+	if err != nil {
+		warnf("write report %s failed: %s", reportFile, err)
+		return
+	}
+	result.AddFile(reportFile)
+}
+
+func buildReport(cmdErr error) reportData {
+	data := reportData{
+		Title:    "Blueprint " + result.Command + " report",
+		Command:  logger.Redact(strings.Join(os.Args, " ")),
+		Time:     commandStart.Format(time.RFC3339),
+		Env:      config.Env,
+		Success:  cmdErr == nil,
+		Duration: formatDuration(time.Since(commandStart)),
+		Warnings: result.Warnings,
+	}
+	if cmdErr != nil {
+		data.Error = logger.Redact(cmdErr.Error())
+	}
+
+	for _, dbResult := range result.Databases {
+		db := reportDatabase{
+			Database: dbResult.Database,
+			Batch:    dbResult.Batch,
+			Error:    result.DatabaseError(dbResult, cmdErr),
+		}
+		for _, m := range dbResult.Migrations {
+			if m.Status == StatusSkipped {
+				db.Skipped++
+				continue
+			}
+			if m.Status == StatusNotApplicable {
+				db.NotApplicable++
+				continue
+			}
+			migration := reportMigration{
+				Name:   m.Name,
+				Batch:  m.Batch,
+				Status: m.Status,
+				Notes:  strings.Join(m.Notes, ", "),
+				Error:  logger.Redact(m.Error),
+			}
+			if len(m.Statements) > 0 {
+				migration.Duration = formatDuration(m.Duration)
+			}
+			for _, statement := range m.Statements {
+				migration.Statements = append(migration.Statements, reportStatement{
+					SQL:          logger.Redact(statement.SQL),
+					Duration:     formatDuration(statement.Duration),
+					RowsAffected: statement.RowsAffected,
+					Error:        logger.Redact(statement.Error),
+				})
+			}
+			db.Migrations = append(db.Migrations, migration)
+		}
+		// 这个 batch 下没有任何记录
+		if len(db.Migrations) == 0 {
+			db.Batch = 0
+		}
+		data.Databases = append(data.Databases, db)
+	}
+	return data
+}
+
+func renderMarkdownReport(data reportData) []byte {
+	var buf bytes.Buffer
+	outcome := "success"
+	if !data.Success {
+		outcome = "failed"
+	}
+
+	fmt.Fprintf(&buf, "# %s\n\n", data.Title)
+	fmt.Fprintf(&buf, "- Command: %s\n", markdownCode(data.Command))
+	fmt.Fprintf(&buf, "- Started: %s\n", data.Time)
+	if data.Env != "" {
+		fmt.Fprintf(&buf, "- Env: %s\n", data.Env)
+	}
+	fmt.Fprintf(&buf, "- Duration: %s\n", data.Duration)
+	fmt.Fprintf(&buf, "- Outcome: **%s**\n", outcome)
+	if data.Error != "" {
+		fence := markdownFence(data.Error)
+		fmt.Fprintf(&buf, "\n## Error\n\n%s\n%s\n%s\n", fence, data.Error, fence)
+	}
+	if len(data.Warnings) > 0 {
+		buf.WriteString("\n## Warnings\n\n")
+		for _, warning := range data.Warnings {
+			fmt.Fprintf(&buf, "- %s\n", markdownEscape(warning))
+		}
+	}
+
+	for _, db := range data.Databases {
+		fmt.Fprintf(&buf, "\n## %s\n\n", db.Database)
+		if db.Batch > 0 {
+			fmt.Fprintf(&buf, "Batch: %d\n\n", db.Batch)
+		}
+		if db.Error != "" {
+			fmt.Fprintf(&buf, "Error: %s\n\n", markdownCode(db.Error))
+		}
+		if db.Skipped > 0 {
+			fmt.Fprintf(&buf, "%d already applied migration(s) skipped.\n\n", db.Skipped)
+		}
+		if db.NotApplicable > 0 {
+			fmt.Fprintf(&buf, "%d migration(s) not applicable to this env skipped.\n\n", db.NotApplicable)
+		}
+		if len(db.Migrations) == 0 {
+			buf.WriteString("Nothing to do.\n")
+			continue
+		}
+
+		buf.WriteString("| Migration | Batch | Status | Duration | Notes |\n")
+		buf.WriteString("| --- | --- | --- | --- | --- |\n")
+		for _, m := range db.Migrations {
+			batch := ""
+			if m.Batch > 0 {
+				batch = fmt.Sprint(m.Batch)
+			}
+			fmt.Fprintf(&buf, "| %s | %s | %s | %s | %s |\n",
+				markdownEscape(m.Name), batch, m.Status, m.Duration, markdownEscape(m.Notes))
+		}
+
+		for _, m := range db.Migrations {
+			if len(m.Statements) == 0 {
+				continue
+			}
+			fmt.Fprintf(&buf, "\n<details>\n<summary>%s: %d statement(s), %s</summary>\n\n",
+				template.HTMLEscapeString(m.Name), len(m.Statements), m.Duration)
+			var sqlText strings.Builder
+			for _, statement := range m.Statements {
+				sqlText.WriteString(statement.SQL)
+				sqlText.WriteString(statement.Error)
+			}
+			fence := markdownFence(sqlText.String())
+			buf.WriteString(fence + "sql\n")
+			for _, statement := range m.Statements {
+				if statement.Error != "" {
+					fmt.Fprintf(&buf, "-- failed after %s: %s\n", statement.Duration, strings.ReplaceAll(statement.Error, "\n", " "))
+				} else {
+					fmt.Fprintf(&buf, "-- %s, %d row(s) affected\n", statement.Duration, statement.RowsAffected)
+				}
+				fmt.Fprintf(&buf, "%s;\n\n", strings.TrimSpace(statement.SQL))
+			}
+			buf.Truncate(buf.Len() - 1)
+			buf.WriteString(fence + "\n\n</details>\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+// s 中最长的连续反引号的长度
+func longestBacktickRun(s string) int {
+	longest, run := 0, 0
+	for _, r := range s {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return longest
+}
+
+// 比内容中任何连续反引号都长的代码块围栏，内容不会提前结束代码块
+func markdownFence(content string) string {
+	return strings.Repeat("`", max(3, longestBacktickRun(content)+1))
+}
+
+// 把 s 渲染为单行的行内代码，分隔的反引号比 s 中的都多
+func markdownCode(s string) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	delimiter := strings.Repeat("`", longestBacktickRun(s)+1)
+	// 以反引号开头或结尾时需要加空格，渲染时会被去掉
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		s = " " + s + " "
+	}
+	return delimiter + s + delimiter
+}
+
+// 避免值破坏表格的行
+func markdownEscape(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #24292f; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #d0d7de; padding: 4px 10px; text-align: left; }
+pre { background: #f6f8fa; padding: 1em; overflow-x: auto; }
+.success { color: #1a7f37; } .failed { color: #cf222e; }
+.comment { color: #6e7781; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<ul>
+<li>Command: <code>{{.Command}}</code></li>
+<li>Started: {{.Time}}</li>
+{{- if .Env}}
+<li>Env: {{.Env}}</li>
+{{- end}}
+<li>Duration: {{.Duration}}</li>
+<li>Outcome: {{if .Success}}<strong class="success">success</strong>{{else}}<strong class="failed">failed</strong>{{end}}</li>
+</ul>
+{{- if .Error}}
+<h2>Error</h2>
+<pre class="failed">{{.Error}}</pre>
+{{- end}}
+{{- if .Warnings}}
+<h2>Warnings</h2>
+<ul>
+{{- range .Warnings}}
+<li>{{.}}</li>
+{{- end}}
+</ul>
+{{- end}}
+{{- range .Databases}}
+<h2>{{.Database}}</h2>
+{{- if .Batch}}
+<p>Batch: {{.Batch}}</p>
+{{- end}}
+{{- if .Error}}
+<p class="failed">Error: {{.Error}}</p>
+{{- end}}
+{{- if .Skipped}}
+<p>{{.Skipped}} already applied migration(s) skipped.</p>
+{{- end}}
+{{- if .NotApplicable}}
+<p>{{.NotApplicable}} migration(s) not applicable to this env skipped.</p>
+{{- end}}
+{{- if .Migrations}}
+<table>
+<tr><th>Migration</th><th>Batch</th><th>Status</th><th>Duration</th><th>Notes</th></tr>
+{{- range .Migrations}}
+<tr><td>{{.Name}}</td><td>{{if .Batch}}{{.Batch}}{{end}}</td><td{{if eq .Status "failed"}} class="failed"{{end}}>{{.Status}}</td><td>{{.Duration}}</td><td>{{.Notes}}</td></tr>
+{{- end}}
+</table>
+{{- range .Migrations}}
+{{- if .Statements}}
+<details>
+<summary>{{.Name}}: {{len .Statements}} statement(s), {{.Duration}}</summary>
+<pre>
+{{- range .Statements}}
+{{if .Error}}<span class="failed">-- failed after {{.Duration}}: {{.Error}}</span>{{else}}<span class="comment">-- {{.Duration}}, {{.RowsAffected}} row(s) affected</span>{{end}}
+{{.SQL}};
+{{end -}}
+</pre>
+</details>
+{{- end}}
+{{- end}}
+{{- else}}
+<p>Nothing to do.</p>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+func renderHTMLReport(data reportData) ([]byte, error) {
+	var buf bytes.Buffer
+	err := htmlReportTemplate.Execute(&buf, data)
+	return buf.Bytes(), err
+}
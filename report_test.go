@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLongestBacktickRun(t *testing.T) {
+	tests := map[string]int{"": 0, "SELECT 1": 0, "`a`": 1, "``` x ` y ``": 3, "a````": 4}
+	for s, want := range tests {
+		if got := longestBacktickRun(s); got != want {
+			t.Errorf("longestBacktickRun(%q) = %d, want %d", s, got, want)
+		}
+	}
+}
+
+func TestMarkdownFence(t *testing.T) {
+	tests := map[string]string{
+		"SELECT 1":                 "```",
+		"SELECT '``' AS a":         "```",
+		"-- ```sql\nSELECT 1\n```": "````",
+		"x `````":                  "``````",
+	}
+	for content, want := range tests {
+		if got := markdownFence(content); got != want {
+			t.Errorf("markdownFence(%q) = %q, want %q", content, got, want)
+		}
+	}
+}
+
+func TestMarkdownCode(t *testing.T) {
+	tests := map[string]string{
+		"blueprint run":            "`blueprint run`",
+		"blueprint run\n--dry-run": "`blueprint run --dry-run`",
+		"echo `date`":              "`` echo `date` ``",
+		"a `` b":                   "```a `` b```",
+		"`start":                   "`` `start ``",
+	}
+	for s, want := range tests {
+		if got := markdownCode(s); got != want {
+			t.Errorf("markdownCode(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestMarkdownEscape(t *testing.T) {
+	tests := map[string]string{
+		"000001_create_users": "000001_create_users",
+		"a|b":                 `a\|b`,
+		"line1\nline2":        "line1 line2",
+	}
+	for s, want := range tests {
+		if got := markdownEscape(s); got != want {
+			t.Errorf("markdownEscape(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+// 报告中的值来自 SQL 和错误信息，不能破坏 Markdown 代码块或注入 HTML
+func testReportData() reportData {
+	return reportData{
+		Title:    "Blueprint run report",
+		Command:  "blueprint run",
+		Time:     "2024-11-18T16:53:01Z",
+		Env:      "prod",
+		Duration: "1.2s",
+		Error:    "exec failed: ```\n<script>alert(1)</script>",
+		Warnings: []string{"<b>warning</b> | pipe"},
+		Databases: []reportDatabase{{
+			Database: "mysql <db>",
+			Batch:    2,
+			Skipped:  1,
+			Migrations: []reportMigration{{
+				Name:     "000002_<add>_index",
+				Batch:    2,
+				Status:   StatusFailed,
+				Notes:    "a|b",
+				Duration: "1s",
+				Statements: []reportStatement{
+					{SQL: "SELECT '```' AS fence", Duration: "1ms", RowsAffected: 1},
+					{SQL: "INSERT INTO t VALUES ('</pre><script>')", Duration: "2ms", Error: "Error 1064:\nnear '<'"},
+				},
+			}},
+		}},
+	}
+}
+
+func TestRenderMarkdownReport(t *testing.T) {
+	content := string(renderMarkdownReport(testReportData()))
+	for _, want := range []string{
+		"- Outcome: **failed**",
+		"````\nexec failed: ```\n<script>alert(1)</script>\n````",
+		"- <b>warning</b> \\| pipe",
+		"Batch: 2",
+		"1 already applied migration(s) skipped.",
+		"| 000002_<add>_index | 2 | failed | 1s | a\\|b |",
+		"<summary>000002_&lt;add&gt;_index: 2 statement(s), 1s</summary>",
+		"````sql\n-- 1ms, 1 row(s) affected\nSELECT '```' AS fence;\n\n-- failed after 2ms: Error 1064: near '<'\nINSERT INTO t VALUES ('</pre><script>');\n````",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("markdown report does not contain %q:\n%s", want, content)
+		}
+	}
+}
+
+func TestRenderHTMLReport(t *testing.T) {
+	content, err := renderHTMLReport(testReportData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(content)
+	for _, leak := range []string{"<script>", "</pre><script>", "<b>warning</b>", "mysql <db>"} {
+		if strings.Contains(html, leak) {
+			t.Errorf("html report contains unescaped %q", leak)
+		}
+	}
+	for _, want := range []string{
+		"&lt;script&gt;alert(1)&lt;/script&gt;",
+		"<h2>mysql &lt;db&gt;</h2>",
+		`<td class="failed">failed</td>`,
+		"INSERT INTO t VALUES (&#39;&lt;/pre&gt;&lt;script&gt;&#39;);",
+		`<strong class="failed">failed</strong>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("html report does not contain %q:\n%s", want, html)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// seed 所在的目录，位于仓库目录下，不会被当作 migration 加载。
+// 目录下的文件在所有环境中执行，<env> 子目录下的文件只在该环境中执行
+const SeedsDirName = "seeds"
+
+// CSV 中表示 NULL 的值
+const csvNull = `\N`
+
+type SeedKind string
+
+const (
+	SeedSQL  SeedKind = "sql"  // 直接执行的 SQL
+	SeedCSV  SeedKind = "csv"  // 第一行为列名
+	SeedJSON SeedKind = "json" // 对象数组，键为列名
+)
+
+type SeedInfo struct {
+	Name     string // 相对于 seeds 目录的路径，不含扩展名，如 users、dev/users
+	Filename string
+	Kind     SeedKind
+	Table    string // 文件名去掉扩展名和排序用的数字前缀，为 csv/json 写入的表
+}
+
+// 文件名开头用于排序的数字前缀，如 01_users.csv
+var seedOrderPrefix = regexp.MustCompile(`^\d+_`)
+
+// 读取所有环境通用的 seed 和 env 环境的 seed，各自按文件名排序
+func LoadSeeds(workDir, env string) ([]SeedInfo, error) {
+	seedsDir := filepath.Join(workDir, SeedsDirName)
+	seeds, err := readSeedDir(seedsDir, "")
+	if err != nil {
+		return nil, err
+	}
+	if env == "" {
+		return seeds, nil
+	}
+	envSeeds, err := readSeedDir(filepath.Join(seedsDir, env), env)
+	if err != nil {
+		return nil, err
+	}
+	return append(seeds, envSeeds...), nil
+}
+
+func readSeedDir(dir, env string) ([]SeedInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	seeds := make([]SeedInfo, 0)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		filename := entry.Name()
+		ext := filepath.Ext(filename)
+		kind := SeedKind(strings.ToLower(strings.TrimPrefix(ext, ".")))
+		switch kind {
+		case SeedSQL, SeedCSV, SeedJSON:
+		default:
+			continue
+		}
+		base := strings.TrimSuffix(filename, ext)
+		name := base
+		if env != "" {
+			name = env + "/" + base
+		}
+		seeds = append(seeds, SeedInfo{
+			Name:     name,
+			Filename: filepath.Join(dir, filename),
+			Kind:     kind,
+			Table:    seedOrderPrefix.ReplaceAllString(base, ""),
+		})
+	}
+	sort.Slice(seeds, func(i, j int) bool {
+		return seeds[i].Name < seeds[j].Name
+	})
+	return seeds, nil
+}
+
+// 按名称选出 seed，名称可以是 Name，也可以是不含环境目录的文件名，文件名可以省略扩展名和数字前缀
+func selectSeeds(seeds []SeedInfo, name string) []SeedInfo {
+	selected := make([]SeedInfo, 0)
+	for _, seed := range seeds {
+		base := filepath.Base(seed.Filename)
+		if seed.Name == name || base == name || strings.TrimSuffix(base, filepath.Ext(base)) == name || seed.Table == name {
+			selected = append(selected, seed)
+		}
+	}
+	return selected
+}
+
+// 执行 seed。没有指定名称时只执行还没执行过或文件有变化的 seed，force 为 true 时全部执行；
+// truncate 为 true 时 csv/json 写入前先清空表
+func runSeeds(workDir string, dbs []*DBConnection, name string, force, truncate bool) error {
+	seeds, err := LoadSeeds(workDir, config.Env)
+	if err != nil {
+		return withCode(ExitConfig, err)
+	}
+	if name != "" {
+		seeds = selectSeeds(seeds, name)
+		if len(seeds) == 0 {
+			return usageError("no seed matches " + name)
+		}
+		force = true
+	}
+	if len(seeds) == 0 {
+		return errorf(ExitNothingToDo, "no seeds in %s", filepath.Join(workDir, SeedsDirName))
+	}
+
+	contents := make(map[string][]byte, len(seeds))
+	for _, seed := range seeds {
+		content, err := os.ReadFile(seed.Filename)
+		if err != nil {
+			return withCode(ExitConfig, err)
+		}
+		contents[seed.Name] = content
+	}
+
+	for _, db := range dbs {
+		dbResult := result.AddDatabase(db)
+
+		err := db.Driver.CheckSeedTable(db.DB)
+		if err != nil {
+			return errorf(ExitDatabase, "check seed table failed: %s", err.Error())
+		}
+		recs, err := db.Driver.GetSeedRecords(db.DB)
+		if err != nil {
+			return errorf(ExitDatabase, "get seed records error: %s", err.Error())
+		}
+		checksums := make(map[string]string)
+		for _, rec := range recs {
+			checksums[rec.Seed] = rec.Checksum
+		}
+		pending := make([]string, 0)
+		running := make(map[string]bool)
+		for _, seed := range seeds {
+			if force || checksums[seed.Name] != sqlChecksum(string(contents[seed.Name])) {
+				pending = append(pending, seed.Name)
+				running[seed.Name] = true
+			}
+		}
+
+		logger.Info(fmt.Sprintf("Database[%s]", db.Config.Label()))
+		// seed 只有 before_run/after_run 钩子，migration 前后的钩子不会执行
+		hooks := hookContext{workDir: workDir, db: db, span: dbResult.span, migrations: pending}
+		if len(pending) > 0 {
+			err = runHooks(HookBeforeRun, hooks, db.DB)
+			if err != nil {
+				return withCode(ExitRefused, err)
+			}
+		}
+		notifyStart(dbResult, pending)
+		err = DoTransaction(db.DB, func(tx *sql.Tx) error {
+			for _, seed := range seeds {
+				checksum := sqlChecksum(string(contents[seed.Name]))
+				if !running[seed.Name] {
+					logger.Info(fmt.Sprintf("  %s had seeded and is unchanged, skip", seed.Name))
+					dbResult.AddMigration(seed.Name, 0, StatusSkipped)
+					continue
+				}
+
+				logger.Info(fmt.Sprintf("  %s", seed.Name))
+				seedResult := dbResult.AddMigration(seed.Name, 0, StatusApplied)
+				seedResult.Checksum = checksum
+				span := tracer.StartSpan(dbResult.span, "seed "+seed.Name, spanKindInternal, "blueprint.seed", seed.Name)
+				start := time.Now()
+				var statements []*StatementResult
+				var err error
+				if seed.Kind == SeedSQL {
+					statements, err = db.ExecMigration(tx, string(contents[seed.Name]), span)
+				} else {
+					statements, err = loadFixture(db, tx, seed, contents[seed.Name], truncate)
+				}
+				seedResult.SetStatements(statements, time.Since(start))
+				span.End(err)
+				if err != nil {
+					seedResult.Status, seedResult.Error = StatusFailed, err.Error()
+					return fmt.Errorf("seed %s failed: %w", seed.Name, err)
+				}
+
+				err = db.Driver.SaveSeedRecord(tx, SeedRec{
+					Seed:     seed.Name,
+					Checksum: checksum,
+					Env:      config.Env,
+					SeededAt: time.Now().Format(time.RFC3339),
+				})
+				if err != nil {
+					seedResult.Status, seedResult.Error = StatusFailed, "record seed failed: "+err.Error()
+					return err
+				}
+			}
+			return nil
+		})
+		if len(pending) > 0 {
+			// 事务已经结束，after_run 钩子失败只给出警告
+			if hookErr := runHooks(HookAfterRun, hooks.withStatus(err), db.DB); hookErr != nil {
+				warnf("%s", hookErr)
+			}
+		}
+		if err != nil {
+			dbResult.MarkNotRecorded()
+			dbResult.Error = err.Error()
+		}
+		notifyDatabaseFinished(dbResult, err)
+		if err != nil {
+			return withCode(ExitMigration, err)
+		}
+	}
+
+	return nil
+}
+
+// 把 csv/json 中的行写入表，每行一条 INSERT
+func loadFixture(db *DBConnection, tx *sql.Tx, seed SeedInfo, content []byte, truncate bool) ([]*StatementResult, error) {
+	var rows []fixtureRow
+	var err error
+	if seed.Kind == SeedCSV {
+		rows, err = parseCSVFixture(content)
+	} else {
+		rows, err = parseJSONFixture(content)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 同样的语句只记录一次，累计耗时和影响行数，避免每行一条结果
+	results := make([]*StatementResult, 0)
+	byStatement := make(map[string]*StatementResult)
+	exec := func(statement string, args ...any) (int64, error) {
+		stmtResult, exist := byStatement[statement]
+		if !exist {
+			stmtResult = &StatementResult{SQL: statement}
+			byStatement[statement] = stmtResult
+			results = append(results, stmtResult)
+		}
+		start := time.Now()
+		res, err := tx.Exec(statement, args...)
+		stmtResult.Duration += time.Since(start)
+		stmtResult.DurationMs = durationMs(stmtResult.Duration)
+		if err != nil {
+			stmtResult.Error = err.Error()
+			return 0, err
+		}
+		// 部分驱动不支持 RowsAffected，此时记为 0
+		affected, _ := res.RowsAffected()
+		stmtResult.RowsAffected += affected
+		return affected, nil
+	}
+
+	table := db.Driver.QuoteIdentifier(seed.Table)
+	if truncate {
+		// DELETE 可以在事务中回滚，各数据库都支持
+		deleted, err := exec("DELETE FROM " + table)
+		if err != nil {
+			return results, err
+		}
+		logger.Info(fmt.Sprintf("    %d row(s) deleted from %s", deleted, seed.Table))
+	}
+
+	for idx, row := range rows {
+		if len(row.columns) == 0 {
+			return results, fmt.Errorf("row %d has no columns", idx+1)
+		}
+		columns := make([]string, 0, len(row.columns))
+		placeholders := make([]string, 0, len(row.columns))
+		for i, column := range row.columns {
+			columns = append(columns, db.Driver.QuoteIdentifier(column))
+			placeholders = append(placeholders, db.Driver.Placeholder(i+1))
+		}
+		statement := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+			table, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+		logger.Debug("exec seed statement", "db", db.Config.Label(), "sql", statement)
+		_, err := exec(statement, row.values...)
+		if err != nil {
+			return results, fmt.Errorf("row %d: %w", idx+1, err)
+		}
+	}
+	logger.Info(fmt.Sprintf("    %d row(s) inserted into %s", len(rows), seed.Table))
+	return results, nil
+}
+
+type fixtureRow struct {
+	columns []string
+	values  []any
+}
+
+// 第一行为列名，值为 \N 时写入 NULL
+func parseCSVFixture(content []byte) ([]fixtureRow, error) {
+	reader := csv.NewReader(bytes.NewReader(content))
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]fixtureRow, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := fixtureRow{columns: header, values: make([]any, len(record))}
+		for i, value := range record {
+			if value == csvNull {
+				row.values[i] = nil
+			} else {
+				row.values[i] = value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// 对象数组，每个对象按键名排序作为列；对象和数组的值以 JSON 文本写入
+func parseJSONFixture(content []byte) ([]fixtureRow, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var objects []map[string]any
+	err := decoder.Decode(&objects)
+	if err != nil {
+		return nil, errors.New("must be an array of objects: " + err.Error())
+	}
+
+	rows := make([]fixtureRow, 0, len(objects))
+	for _, object := range objects {
+		row := fixtureRow{}
+		for column := range object {
+			row.columns = append(row.columns, column)
+		}
+		sort.Strings(row.columns)
+		for _, column := range row.columns {
+			switch value := object[column].(type) {
+			case json.Number:
+				row.values = append(row.values, value.String())
+			case map[string]any, []any:
+				text, err := json.Marshal(value)
+				if err != nil {
+					return nil, err
+				}
+				row.values = append(row.values, string(text))
+			default:
+				row.values = append(row.values, value)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
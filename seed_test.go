@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSeedIsAuditedNotifiedAndHooked(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the command hook uses sh")
+	}
+	server := newWebhookServer(t)
+	setupWebhookTest(t, WebhookConfig{URL: server.URL, Timeout: 5})
+	result.Command = "seed"
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		filepath.Join(SeedsDirName, "01_users.csv"): "id,name\n1,alice\n",
+		filepath.Join(SeedsDirName, "02_posts.sql"): "INSERT INTO posts VALUES (1);",
+	})
+	config.Audit = &AuditConfig{File: "audit.jsonl"}
+	config.Hooks = &HooksConfig{
+		BeforeRun:      []HookConfig{{Command: `echo "$BLUEPRINT_HOOK $BLUEPRINT_COMMAND $BLUEPRINT_MIGRATIONS" >> hook.log`}},
+		AfterMigration: []HookConfig{{Command: "echo migration >> hook.log"}},
+	}
+	db := openTestSQLite(t, dir, "CREATE TABLE users (id INT, name TEXT)", "CREATE TABLE posts (id INT)")
+
+	err := runSeeds(dir, []*DBConnection{db}, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAudit(dir, nil)
+
+	content, err := os.ReadFile(filepath.Join(dir, "hook.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "before_run seed 01_users,02_posts\n"; string(content) != want {
+		t.Errorf("hook.log = %q, want %q", content, want)
+	}
+
+	requests := server.requests()
+	if len(requests) != 2 {
+		t.Fatalf("got %d webhook request(s), want start and success", len(requests))
+	}
+	for idx, want := range []WebhookEvent{WebhookStart, WebhookSuccess} {
+		payload := WebhookPayload{}
+		_ = json.Unmarshal([]byte(requests[idx]), &payload)
+		if payload.Event != want || payload.Command != "seed" || strings.Join(payload.Migrations, ",") != "01_users,02_posts" {
+			t.Errorf("webhook %d = %+v", idx, payload)
+		}
+	}
+
+	content, err = os.ReadFile(filepath.Join(dir, "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := AuditRecord{}
+	err = json.Unmarshal(content, &record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Command != "seed" || record.Outcome != "success" || len(record.Migrations) != 2 {
+		t.Errorf("audit record = %+v", record)
+	}
+}
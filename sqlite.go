@@ -41,21 +41,45 @@ func (d SQLiteDriver) CheckMigrationInfoTable(db *sql.DB) error {
 			CREATE TABLE migrations (
 			  id INTEGER PRIMARY KEY AUTOINCREMENT,
 			  migration VARCHAR(255) NOT NULL,
-			  batch INTEGER NOT NULL
+			  batch INTEGER NOT NULL,
+			  down_sql TEXT NULL
 			);
 		`)
 		if err != nil {
 			return err
 		}
+		return nil
+	}
+	rows.Close()
+
+	// Tables created by older versions have no down_sql column
+	exists, err := d.hasColumn(db, "migrations", "down_sql")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		_, err := db.Exec("ALTER TABLE migrations ADD COLUMN down_sql TEXT NULL")
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+func (d SQLiteDriver) hasColumn(db *sql.DB, table, column string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
 func (d SQLiteDriver) GetMigrationInfos(db *sql.DB) ([]MigrationRec, error) {
 	info := make([]MigrationRec, 0)
 
-	rows, err := db.Query("SELECT id, migration, batch FROM migrations")
+	rows, err := db.Query("SELECT id, migration, batch, down_sql FROM migrations")
 	if err != nil {
 		return nil, err
 	}
@@ -63,7 +87,7 @@ func (d SQLiteDriver) GetMigrationInfos(db *sql.DB) ([]MigrationRec, error) {
 
 	for rows.Next() {
 		rec := MigrationRec{}
-		err = rows.Scan(&rec.Id, &rec.Migration, &rec.Batch)
+		err = rows.Scan(&rec.Id, &rec.Migration, &rec.Batch, &rec.DownSQL)
 		if err != nil {
 			return nil, err
 		}
@@ -75,9 +99,9 @@ func (d SQLiteDriver) GetMigrationInfos(db *sql.DB) ([]MigrationRec, error) {
 
 func (d SQLiteDriver) InsertMigrationInfo(db *sql.Tx, info MigrationRec) error {
 	_, err := db.Exec(`
-		INSERT INTO migrations (migration, batch)
-		VALUES (?, ?);
-	`, info.Migration, info.Batch)
+		INSERT INTO migrations (migration, batch, down_sql)
+		VALUES (?, ?, ?);
+	`, info.Migration, info.Batch, info.DownSQL)
 	if err != nil {
 		return err
 	}
@@ -90,22 +114,18 @@ func (d SQLiteDriver) DeleteMigrationInfo(db *sql.Tx, id uint) error {
 	return err
 }
 
-func (d SQLiteDriver) ExecMigration(db *sql.Tx, migrationSQL string) error {
-	statements := strings.Split(migrationSQL, ";")
-
-	for _, statement := range statements {
+func (d SQLiteDriver) SplitStatements(migrationSQL string) []string {
+	statements := make([]string, 0)
+	for _, statement := range strings.Split(migrationSQL, ";") {
 		statement = strings.TrimSpace(statement)
-		if statement == "" {
+		// Skip comment-only statements, e.g. a directive after the last statement
+		if IsEmptySQL(statement) {
 			continue
 		}
-
-		_, err := db.Exec(statement)
-		if err != nil {
-			return err
-		}
+		statements = append(statements, statement)
 	}
 
-	return nil
+	return statements
 }
 
 func (d SQLiteDriver) ShowTableCreate(db *sql.DB, table string) (string, error) {
@@ -130,6 +150,86 @@ func (d SQLiteDriver) ShowTableCreate(db *sql.DB, table string) (string, error)
 	return "", errors.New("table not found or no creation sql available")
 }
 
+func (d SQLiteDriver) CheckAuditTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS ` + AuditTableName + ` (
+		  id INTEGER PRIMARY KEY AUTOINCREMENT,
+		  created_at VARCHAR(64) NOT NULL,
+		  os_user VARCHAR(255) NOT NULL,
+		  host VARCHAR(255) NOT NULL,
+		  env VARCHAR(64) NOT NULL,
+		  command VARCHAR(64) NOT NULL,
+		  outcome VARCHAR(16) NOT NULL,
+		  record TEXT NOT NULL
+		);
+	`)
+	return err
+}
+
+func (d SQLiteDriver) InsertAuditRecord(db *sql.DB, record AuditRecord, content string) error {
+	_, err := db.Exec(`
+		INSERT INTO `+AuditTableName+` (created_at, os_user, host, env, command, outcome, record)
+		VALUES (?, ?, ?, ?, ?, ?, ?);
+	`, record.Time, record.User, record.Host, record.Env, record.Command, record.Outcome, content)
+	return err
+}
+
+func (d SQLiteDriver) Placeholder(n int) string {
+	return "?"
+}
+
+func (d SQLiteDriver) QuoteIdentifier(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = `"` + strings.ReplaceAll(part, `"`, `""`) + `"`
+	}
+	return strings.Join(parts, ".")
+}
+
+func (d SQLiteDriver) CheckSeedTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS ` + SeedTableName + ` (
+		  id INTEGER PRIMARY KEY AUTOINCREMENT,
+		  seed VARCHAR(255) NOT NULL UNIQUE,
+		  checksum VARCHAR(64) NOT NULL,
+		  env VARCHAR(64) NOT NULL,
+		  seeded_at VARCHAR(64) NOT NULL
+		);
+	`)
+	return err
+}
+
+func (d SQLiteDriver) GetSeedRecords(db *sql.DB) ([]SeedRec, error) {
+	recs := make([]SeedRec, 0)
+	rows, err := db.Query("SELECT seed, checksum, env, seeded_at FROM " + SeedTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rec := SeedRec{}
+		err = rows.Scan(&rec.Seed, &rec.Checksum, &rec.Env, &rec.SeededAt)
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+	return recs, rows.Err()
+}
+
+// SaveSeedRecord replaces the previous record of the seed
+func (d SQLiteDriver) SaveSeedRecord(db *sql.Tx, rec SeedRec) error {
+	_, err := db.Exec(`DELETE FROM `+SeedTableName+` WHERE seed = ?`, rec.Seed)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		INSERT INTO `+SeedTableName+` (seed, checksum, env, seeded_at)
+		VALUES (?, ?, ?, ?);
+	`, rec.Seed, rec.Checksum, rec.Env, rec.SeededAt)
+	return err
+}
+
 func (d SQLiteDriver) GetTables(db *sql.DB) ([]string, error) {
 	tables := make([]string, 0)
 	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%'")
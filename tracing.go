@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 通过 OTLP/HTTP（JSON 编码）把 span 导出到 OpenTelemetry collector
+type TracingConfig struct {
+	Endpoint    string            `json:"endpoint,omitempty"`     // 如 http://localhost:4318，会自动加上 /v1/traces
+	ServiceName string            `json:"service_name,omitempty"` // 默认为 blueprint
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+// OTLP 的 span kind 和状态码
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+
+	spanStatusError = 2
+)
+
+// 根 span 的开始时间
+var commandStart = time.Now()
+
+// nil 的 span 不记录任何内容，调用方不需要判断是否开启了 tracing
+type Span struct {
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    []spanAttr
+	err      string
+}
+
+type spanAttr struct {
+	key   string
+	value any
+}
+
+// 保存当前命令的 span，直到导出
+type Tracer struct {
+	url         string
+	serviceName string
+	headers     map[string]string
+	root        *Span
+	spans       []*Span
+}
+
+var tracer = &Tracer{}
+
+// blueprint.json 或 OTEL_EXPORTER_OTLP_* 环境变量配置了 endpoint 时开启 tracing，
+// 有 TRACEPARENT 环境变量时挂到上游（如部署流水线）的 trace 下
+func (t *Tracer) Enable(cfg *TracingConfig) {
+	if cfg == nil {
+		cfg = &TracingConfig{}
+	}
+	t.url = os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if t.url == "" {
+		endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if endpoint == "" {
+			endpoint = cfg.Endpoint
+		}
+		if endpoint == "" {
+			return
+		}
+		t.url = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	t.serviceName = os.Getenv("OTEL_SERVICE_NAME")
+	if t.serviceName == "" {
+		t.serviceName = cfg.ServiceName
+	}
+	if t.serviceName == "" {
+		t.serviceName = "blueprint"
+	}
+	t.headers = cfg.Headers
+
+	root := &Span{
+		traceID: randomHex(16),
+		spanID:  randomHex(8),
+		name:    "blueprint " + result.Command,
+		kind:    spanKindInternal,
+		start:   commandStart,
+	}
+	if traceID, parentID, ok := parseTraceparent(os.Getenv("TRACEPARENT")); ok {
+		root.traceID, root.parentID = traceID, parentID
+	}
+	root.SetAttr("blueprint.command", result.Command, "deployment.environment", config.Env)
+	t.root = root
+	t.spans = append(t.spans, root)
+}
+
+// 开始 parent 的子 span，parent 为 nil 时挂在根 span 下
+func (t *Tracer) StartSpan(parent *Span, name string, kind int, kv ...any) *Span {
+	if t.root == nil {
+		return nil
+	}
+	if parent == nil {
+		parent = t.root
+	}
+	span := &Span{
+		traceID:  parent.traceID,
+		spanID:   randomHex(8),
+		parentID: parent.spanID,
+		name:     name,
+		kind:     kind,
+		start:    time.Now(),
+	}
+	span.SetAttr(kv...)
+	t.spans = append(t.spans, span)
+	return span
+}
+
+func (s *Span) SetAttr(kv ...any) {
+	if s == nil {
+		return
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		s.attrs = append(s.attrs, spanAttr{key: fmt.Sprint(kv[i]), value: kv[i+1]})
+	}
+}
+
+// 结束 span，err 不为 nil 时标记为失败；重复调用时以第一次为准
+func (s *Span) End(err error) {
+	if s == nil || !s.end.IsZero() {
+		return
+	}
+	s.end = time.Now()
+	if err != nil {
+		s.err = logger.Redact(err.Error())
+	}
+}
+
+// 结束命令中未结束的 span 并全部导出
+func finishTracing(cmdErr error) {
+	if tracer.root == nil {
+		return
+	}
+	for _, dbResult := range result.Databases {
+		var err error
+		if errText := result.DatabaseError(dbResult, cmdErr); errText != "" {
+			err = errors.New(errText)
+		}
+		dbResult.span.End(err)
+	}
+	tracer.root.End(cmdErr)
+
+	err := tracer.export()
+	if err != nil {
+		warnf("export traces to %s failed: %s", tracer.url, err)
+	}
+}
+
+func (t *Tracer) export() error {
+	spans := make([]map[string]any, 0, len(t.spans))
+	for _, span := range t.spans {
+		// 执行失败时被中断的语句的 span
+		span.End(nil)
+		spans = append(spans, span.otlp())
+	}
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttrs([]spanAttr{{key: "service.name", value: t.serviceName}, {key: "service.version", value: version}}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "blueprint", "version": version},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+
+	logger.Debug("exporting traces", "url", t.url, "spans", len(spans))
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// 按 OTLP/JSON 格式编码
+func (s *Span) otlp() map[string]any {
+	span := map[string]any{
+		"traceId":           s.traceID,
+		"spanId":            s.spanID,
+		"name":              s.name,
+		"kind":              s.kind,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        otlpAttrs(s.attrs),
+	}
+	if s.parentID != "" {
+		span["parentSpanId"] = s.parentID
+	}
+	if s.err != "" {
+		span["status"] = map[string]any{"code": spanStatusError, "message": s.err}
+		span["events"] = []any{map[string]any{
+			"name":         "exception",
+			"timeUnixNano": strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":   otlpAttrs([]spanAttr{{key: "exception.message", value: s.err}}),
+		}}
+	}
+	return span
+}
+
+func otlpAttrs(attrs []spanAttr) []any {
+	encoded := make([]any, 0, len(attrs))
+	for _, attr := range attrs {
+		var value map[string]any
+		switch v := attr.value.(type) {
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			value = map[string]any{"intValue": strconv.FormatInt(int64(v), 10)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case uint:
+			value = map[string]any{"intValue": strconv.FormatUint(uint64(v), 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		default:
+			value = map[string]any{"stringValue": logger.Redact(fmt.Sprint(v))}
+		}
+		encoded = append(encoded, map[string]any{"key": attr.key, "value": value})
+	}
+	return encoded
+}
+
+// 解析 W3C trace context，如 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func parseTraceparent(traceparent string) (traceID, parentID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", "", false
+	}
+	if _, err := hex.DecodeString(parts[1] + parts[2]); err != nil {
+		return "", "", false
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", false
+	}
+	return strings.ToLower(parts[1]), strings.ToLower(parts[2]), true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 数据库类型对应的 OpenTelemetry db.system
+func dbSystem(t DBType) string {
+	switch t {
+	case PG:
+		return "postgresql"
+	case SQLite:
+		return "sqlite"
+	}
+	return "mysql"
+}
+
+// 用语句的第一个关键字（如 CREATE）作为 span 名
+func statementOperation(statement string) string {
+	fields := strings.Fields(statement)
+	if len(fields) == 0 {
+		return "statement"
+	}
+	return strings.ToUpper(fields[0])
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type WebhookEvent string
+
+const (
+	WebhookStart   WebhookEvent = "start"
+	WebhookSuccess WebhookEvent = "success"
+	WebhookFailure WebhookEvent = "failure"
+)
+
+func (e WebhookEvent) IsValid() bool {
+	switch e {
+	case WebhookStart, WebhookSuccess, WebhookFailure:
+		return true
+	}
+	return false
+}
+
+// 会发送 webhook 通知的命令
+var webhookCommands = map[string]bool{
+	"run":      true,
+	"rollback": true,
+	"seed":     true,
+}
+
+// 两次重试之间的等待时间，每次重试递增
+var webhookRetryInterval = time.Second
+
+// 发送给 webhook 的内容，也是 template 的数据
+type WebhookPayload struct {
+	Event      WebhookEvent `json:"event"`
+	Command    string       `json:"command"`
+	Env        string       `json:"env"`
+	Database   string       `json:"database"`
+	Type       DBType       `json:"type"`
+	Batch      uint         `json:"batch,omitempty"`
+	Migrations []string     `json:"migrations"` // seed 时为 seed 的名称
+	DurationMs float64      `json:"duration_ms,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	Time       string       `json:"time"`
+}
+
+func (e WebhookEvent) enabled(webhook WebhookConfig) bool {
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, event := range webhook.Events {
+		if event == e {
+			return true
+		}
+	}
+	return false
+}
+
+// template 中可以用 json 转义字符串，用 join 拼接 migration 名称，如
+// {"text": {{json (printf "%s %s on %s" .Command .Event .Database)}}}
+func parseWebhookTemplate(text string) (*template.Template, error) {
+	return template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			content, err := json.Marshal(v)
+			return string(content), err
+		},
+		"join": strings.Join,
+	}).Parse(text)
+}
+
+// 在数据库上开始执行前通知，migrations 为将要执行的 migration
+func notifyStart(dbResult *DatabaseResult, migrations []string) {
+	if len(config.Webhooks) == 0 || !webhookCommands[result.Command] {
+		return
+	}
+	payload := newWebhookPayload(WebhookStart, dbResult)
+	payload.Migrations = migrations
+	sendWebhooks(payload)
+}
+
+// 一个数据库执行结束后立即通知它的结果，不等其余的数据库。每个数据库只通知一次
+func notifyDatabaseFinished(dbResult *DatabaseResult, err error) {
+	if dbResult.notified || len(config.Webhooks) == 0 || !webhookCommands[result.Command] {
+		return
+	}
+	dbResult.notified = true
+	payload := newWebhookPayload(WebhookSuccess, dbResult)
+	payload.DurationMs = durationMs(time.Since(dbResult.started))
+	if errText := result.DatabaseError(dbResult, err); errText != "" {
+		payload.Event, payload.Error = WebhookFailure, errText
+	}
+	for _, m := range dbResult.Migrations {
+		if m.Status == StatusSkipped || m.Status == StatusPending || m.Status == StatusNotApplicable {
+			continue
+		}
+		payload.Migrations = append(payload.Migrations, m.Name)
+	}
+	sendWebhooks(payload)
+}
+
+// 命令结束后通知还没有通知过的数据库，如在执行前就出错而提前结束的数据库
+func notifyFinished(cmdErr error) {
+	for _, dbResult := range result.Databases {
+		notifyDatabaseFinished(dbResult, cmdErr)
+	}
+}
+
+func newWebhookPayload(event WebhookEvent, dbResult *DatabaseResult) WebhookPayload {
+	return WebhookPayload{
+		Event:      event,
+		Command:    result.Command,
+		Env:        config.Env,
+		Database:   dbResult.Database,
+		Type:       dbResult.Type,
+		Batch:      dbResult.Batch,
+		Migrations: make([]string, 0),
+		Time:       time.Now().Format(time.RFC3339Nano),
+	}
+}
+
+// webhook 出错只给出警告，不影响命令的执行结果
+func sendWebhooks(payload WebhookPayload) {
+	for _, webhook := range config.Webhooks {
+		if !payload.Event.enabled(webhook) {
+			continue
+		}
+		err := sendWebhook(webhook, payload)
+		if err != nil {
+			warnf("webhook %s of %s event on db[%s] failed: %s", webhookLabel(webhook.URL), payload.Event, payload.Database, err)
+		}
+	}
+}
+
+func sendWebhook(webhook WebhookConfig, payload WebhookPayload) error {
+	body, err := renderWebhookBody(webhook, payload)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: time.Duration(webhook.Timeout) * time.Second}
+	for attempt := uint(0); ; attempt++ {
+		err = postWebhook(client, webhook, body)
+		if err == nil || attempt >= webhook.Retries || !retryable(err) {
+			return err
+		}
+		logger.Verbose(fmt.Sprintf("webhook %s failed, retrying", webhookLabel(webhook.URL)), "attempt", attempt+1, "error", err)
+		time.Sleep(webhookRetryInterval * time.Duration(attempt+1))
+	}
+}
+
+func renderWebhookBody(webhook WebhookConfig, payload WebhookPayload) ([]byte, error) {
+	if webhook.Template == "" {
+		return json.Marshal(payload)
+	}
+	tmpl, err := parseWebhookTemplate(webhook.Template)
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	err = tmpl.Execute(&body, payload)
+	if err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+func postWebhook(client *http.Client, webhook WebhookConfig, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "blueprint/"+version)
+	for key, value := range webhook.Headers {
+		req.Header.Set(key, value)
+	}
+
+	logger.Debug("posting webhook", "url", webhookLabel(webhook.URL), "body", string(body))
+	resp, err := client.Do(req)
+	if urlErr, ok := err.(*url.Error); ok {
+		// url.Error 中带有完整地址
+		return urlErr.Err
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &webhookStatusError{Status: resp.Status, Code: resp.StatusCode}
+	}
+	return nil
+}
+
+// webhook 返回了非 2xx 状态码
+type webhookStatusError struct {
+	Status string
+	Code   int
+}
+
+func (e *webhookStatusError) Error() string {
+	return "unexpected status " + e.Status
+}
+
+// 只重试网络错误和 5xx，4xx 说明请求本身有问题，重试也不会成功
+func retryable(err error) bool {
+	var statusErr *webhookStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= 500
+	}
+	return true
+}
+
+// 只输出 webhook 地址的主机部分，chat 工具的 webhook 地址中通常带有 token
+func webhookLabel(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return redacted
+	}
+	return u.Scheme + "://" + u.Host
+}
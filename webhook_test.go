@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 记录收到的请求，按顺序返回 statuses 中的状态码，用完后返回 200
+type webhookServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+	headers  []http.Header
+}
+
+func newWebhookServer(t *testing.T, statuses ...int) *webhookServer {
+	s := &webhookServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.bodies = append(s.bodies, string(body))
+		s.headers = append(s.headers, r.Header.Clone())
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *webhookServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.bodies...)
+}
+
+// 替换全局的 config 和 result，测试结束后恢复
+func setupWebhookTest(t *testing.T, webhooks ...WebhookConfig) {
+	savedConfig, savedResult, savedInterval, savedOut := config, result, webhookRetryInterval, logger.out
+	t.Cleanup(func() {
+		config, result, webhookRetryInterval, logger.out = savedConfig, savedResult, savedInterval, savedOut
+	})
+	config = Config{Env: "staging", Webhooks: webhooks}
+	result = &CommandResult{Command: "run"}
+	webhookRetryInterval = 0
+	logger.out = io.Discard
+}
+
+func newWebhookDatabaseResult() *DatabaseResult {
+	dbResult := &DatabaseResult{Database: "sqlite ./app.db", Type: SQLite, Batch: 3, started: time.Now()}
+	dbResult.AddMigration("000001_create_users", 0, StatusSkipped)
+	dbResult.AddMigration("000002_create_posts", 3, StatusApplied)
+	dbResult.AddMigration("000003_seed_dev", 0, StatusNotApplicable)
+	result.Databases = append(result.Databases, dbResult)
+	return dbResult
+}
+
+func TestNotifyDatabaseFinishedPayload(t *testing.T) {
+	server := newWebhookServer(t)
+	setupWebhookTest(t, WebhookConfig{URL: server.URL, Timeout: 5, Headers: map[string]string{"Authorization": "Bearer token"}})
+
+	tests := []struct {
+		name      string
+		dbError   string
+		wantEvent WebhookEvent
+	}{
+		{"success", "", WebhookSuccess},
+		{"failure", "table posts already exists", WebhookFailure},
+	}
+	for idx, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbResult := newWebhookDatabaseResult()
+			dbResult.Error = tt.dbError
+			notifyDatabaseFinished(dbResult, nil)
+			// 命令结束时不会再次通知已经通知过的数据库
+			notifyFinished(errors.New("later error"))
+
+			requests := server.requests()
+			if len(requests) != idx+1 {
+				t.Fatalf("got %d request(s), want %d", len(requests), idx+1)
+			}
+			if got := server.headers[idx].Get("Authorization"); got != "Bearer token" {
+				t.Errorf("Authorization = %q", got)
+			}
+			if got := server.headers[idx].Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q", got)
+			}
+
+			payload := WebhookPayload{}
+			err := json.Unmarshal([]byte(requests[idx]), &payload)
+			if err != nil {
+				t.Fatalf("decode payload: %s", err)
+			}
+			if payload.Event != tt.wantEvent || payload.Error != tt.dbError {
+				t.Errorf("event = %s, error = %q, want %s, %q", payload.Event, payload.Error, tt.wantEvent, tt.dbError)
+			}
+			if payload.Command != "run" || payload.Env != "staging" || payload.Database != "sqlite ./app.db" || payload.Type != SQLite || payload.Batch != 3 {
+				t.Errorf("unexpected payload: %+v", payload)
+			}
+			if len(payload.Migrations) != 1 || payload.Migrations[0] != "000002_create_posts" {
+				t.Errorf("migrations = %v, want only the applied one", payload.Migrations)
+			}
+		})
+	}
+}
+
+func TestNotifyFinishedUnnotifiedDatabases(t *testing.T) {
+	server := newWebhookServer(t)
+	setupWebhookTest(t, WebhookConfig{URL: server.URL, Timeout: 5, Events: []WebhookEvent{WebhookFailure}})
+
+	first := newWebhookDatabaseResult()
+	notifyDatabaseFinished(first, nil)
+	newWebhookDatabaseResult()
+	notifyFinished(errors.New("check migration info failed"))
+
+	// 只订阅了 failure，第一个数据库成功不会发送，出错的是最后一个数据库
+	requests := server.requests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d request(s), want 1", len(requests))
+	}
+	payload := WebhookPayload{}
+	_ = json.Unmarshal([]byte(requests[0]), &payload)
+	if payload.Event != WebhookFailure || payload.Error != "check migration info failed" {
+		t.Errorf("event = %s, error = %q", payload.Event, payload.Error)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	server := newWebhookServer(t)
+	setupWebhookTest(t, WebhookConfig{
+		URL:      server.URL,
+		Timeout:  5,
+		Template: `{"text": {{json (printf "%s %s on %s" .Command .Event .Database)}}, "migrations": {{json (join .Migrations ", ")}}}`,
+	})
+
+	notifyDatabaseFinished(newWebhookDatabaseResult(), nil)
+
+	want := `{"text": "run success on sqlite ./app.db", "migrations": "000002_create_posts"}`
+	if requests := server.requests(); len(requests) != 1 || requests[0] != want {
+		t.Errorf("body = %q, want %q", requests, want)
+	}
+}
+
+func TestSendWebhookRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		retries      uint
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"success at once", nil, 2, 1, false},
+		{"5xx is retried", []int{500, 502}, 2, 3, false},
+		{"retries exhausted", []int{503, 503, 503}, 1, 2, true},
+		{"4xx is not retried", []int{400}, 2, 1, true},
+		{"401 is not retried", []int{401, 500}, 3, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newWebhookServer(t, tt.statuses...)
+			webhook := WebhookConfig{URL: server.URL, Timeout: 5, Retries: tt.retries}
+			setupWebhookTest(t, webhook)
+
+			err := sendWebhook(webhook, WebhookPayload{Event: WebhookStart})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := len(server.requests()); got != tt.wantAttempts {
+				t.Errorf("got %d attempt(s), want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestSendWebhookNetworkErrorIsRetried(t *testing.T) {
+	server := newWebhookServer(t)
+	server.Close()
+	webhook := WebhookConfig{URL: server.URL, Timeout: 1, Retries: 1}
+	setupWebhookTest(t, webhook)
+
+	err := sendWebhook(webhook, WebhookPayload{Event: WebhookStart})
+	if err == nil {
+		t.Fatal("expected an error from a closed server")
+	}
+	if !retryable(err) {
+		t.Errorf("network error %v should be retried", err)
+	}
+}